@@ -2,64 +2,898 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"os"
+	"sync"
 
 	"sniper/pkg/config"
 	eth "sniper/pkg/eth"
+	"sniper/pkg/ledger"
 	"sniper/pkg/swap"
+	"sniper/pkg/triggers"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/ethclient/gethclient"
 	"github.com/ethereum/go-ethereum/params"
-	"github.com/ethereum/go-ethereum/rpc"
 )
 
-func buyTokens(client *ethclient.Client, sw *swap.DexSwap, dex *swap.Dex, pricer *swap.PriceWatcher) (*types.Receipt, error) {
-	var err error
-	ctx := context.Background()
+type swapResult struct {
+	Wallet    *eth.Wallet
+	AmountIn  *big.Int
+	AmountOut *big.Int
+	GasFees   *big.Int
+	// Tx is nil for positions resumed from the ledger
+	Tx    *types.Transaction
+	Block uint64
+}
+
+// mergeResults aggregates the swaps of several wallets into one position.
+func mergeResults(parts []*swapResult) *swapResult {
+	res := &swapResult{AmountIn: big.NewInt(0), AmountOut: big.NewInt(0), GasFees: big.NewInt(0)}
+	for _, part := range parts {
+		res.AmountIn.Add(res.AmountIn, part.AmountIn)
+		res.AmountOut.Add(res.AmountOut, part.AmountOut)
+		res.GasFees.Add(res.GasFees, part.GasFees)
+	}
+	return res
+}
+
+// sniper holds what the targets share while they are sniped concurrently.
+// Contract reads go through reads, to the fastest healthy endpoint.
+type sniper struct {
+	conf    *config.Config
+	network *eth.Network
+	client  *ethclient.Client
+	reads   *eth.ReadBackend
+	geth    *gethclient.Client
+	wallet  *eth.Wallet
+	// wallets holds the main wallet followed by the pool ones
+	wallets []*eth.Wallet
+	txms    map[common.Address]*eth.TxManager
+	dex     *swap.Dex
+	inToken *eth.Token
+	mempool *triggers.MempoolHub
+	budget  *swap.Budget
+	ledger  *ledger.Ledger
+	// positions are those left open by previous runs
+	positions []*ledger.Position
+}
 
-	tx, err := sw.BuildTx(client, ctx, dex.Router)
+// record appends the event to the ledger. A failure is only logged, the
+// action it records having already happened.
+func (s *sniper) record(ev *ledger.Event) {
+	if s.ledger == nil {
+		return
+	}
+	err := s.ledger.Record(ev)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to build swap transaction: %s", err)
+		log.Printf("Failed to record %s in ledger: %s", ev.Kind, err)
+	}
+}
+
+func (s *sniper) recordSwaps(kind ledger.EventKind, token common.Address, results []*swapResult) {
+	for _, res := range results {
+		s.record(&ledger.Event{
+			Kind:      kind,
+			Wallet:    res.Wallet.Address(),
+			Token:     token,
+			TxHash:    res.Tx.Hash(),
+			Block:     res.Block,
+			AmountIn:  res.AmountIn,
+			AmountOut: res.AmountOut,
+			Cost:      res.AmountIn,
+			GasFees:   res.GasFees,
+		})
+	}
+}
+
+// recordFee records the gas a transaction of the wallet spent without moving
+// a position, token being the one it was about if any.
+func (s *sniper) recordFee(ctx context.Context, wallet *eth.Wallet, token common.Address, tx *types.Transaction, receipt *types.Receipt) {
+	gasFees, err := eth.GetTxGasFees(s.network.Client(), ctx, tx, receipt)
+	if err != nil {
+		log.Printf("Failed to get gas fees of %s: %s", tx.Hash().Hex(), err)
+		return
 	}
+	s.record(&ledger.Event{
+		Kind:    ledger.FeeEvent,
+		Wallet:  wallet.Address(),
+		Token:   token,
+		TxHash:  tx.Hash(),
+		Block:   receipt.BlockNumber.Uint64(),
+		Cost:    tx.Value(),
+		GasFees: gasFees,
+	})
+}
 
-	err = client.SendTransaction(ctx, tx)
+// recordTransfer records the token, or the coins when token is nil, the
+// wallet sent to the main wallet, the position moving along with them.
+func (s *sniper) recordTransfer(ctx context.Context, wallet *eth.Wallet, token *eth.Token, tx *types.Transaction, receipt *types.Receipt) {
+	gasFees, err := eth.GetTxGasFees(s.network.Client(), ctx, tx, receipt)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to send transaction: %s", err)
+		log.Printf("Failed to get gas fees of %s: %s", tx.Hash().Hex(), err)
+		return
+	}
+	to := s.wallet.Address()
+	ev := &ledger.Event{
+		Kind:    ledger.TransferEvent,
+		Wallet:  wallet.Address(),
+		TxHash:  tx.Hash(),
+		Block:   receipt.BlockNumber.Uint64(),
+		Cost:    tx.Value(),
+		GasFees: gasFees,
+		To:      &to,
+	}
+	if token != nil {
+		ev.Token = token.Address
+		ev.AmountIn = swap.GetSentAmount(receipt, token.Address, wallet.Address())
+		ev.AmountOut = swap.GetReceivedAmount(receipt, token.Address, to)
 	} else {
-		log.Printf("Transaction sent: %s", tx.Hash().Hex())
+		ev.AmountIn = tx.Value()
+		ev.AmountOut = tx.Value()
+	}
+	s.record(ev)
+}
+
+// sendTx sends the transaction of the wallet built by build and returns the
+// one that got mined in its place, which differs from it when it had to be
+// replaced.
+func (s *sniper) sendTx(ctx context.Context, wallet *eth.Wallet, build func() (*types.Transaction, error)) (*types.Transaction, *types.Receipt, error) {
+	txm := s.txms[wallet.Address()]
+	tx, err := build()
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := txm.Broadcast(ctx, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = txm.Wait(ctx, t)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error waiting for transaction mining: %s", err)
+	}
+	if t.Cancelled() {
+		s.recordFee(ctx, wallet, common.Address{}, t.Mined, t.Receipt)
+		return nil, nil, fmt.Errorf("Transaction %s was cancelled", tx.Hash().Hex())
+	}
+	if t.Receipt.Status != types.ReceiptStatusSuccessful {
+		s.recordFee(ctx, wallet, common.Address{}, t.Mined, t.Receipt)
+		return nil, nil, fmt.Errorf("Transaction %s reverted", t.Mined.Hash().Hex())
+	}
+	log.Printf("Transaction mined: %s\n", t.Mined.Hash().Hex())
+
+	return t.Mined, t.Receipt, nil
+}
+
+// buildSwap takes the pre-signed transaction of the swap when it still holds,
+// and otherwise signs it again at the next nonce of the wallet.
+func (s *sniper) buildSwap(ctx context.Context, sw *swap.DexSwap, armed *swap.ArmedSwap) (*types.Transaction, error) {
+	if armed != nil {
+		if tx := armed.Tx(sw.AmountIn); tx != nil {
+			log.Printf("Using pre-signed swap transaction %s", tx.Hash().Hex())
+			return tx, nil
+		}
+		log.Printf("Pre-signed swap of %s is outdated, signing it again", sw.TokenOut.Symbol)
+	}
+
+	tx, err := sw.BuildTx(s.network.Client(), ctx, s.dex.Router)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build swap transaction: %s", err)
+	}
+	return tx, nil
+}
+
+func (s *sniper) executeSwap(sw *swap.DexSwap, armed *swap.ArmedSwap) (*swapResult, error) {
+	ctx := context.Background()
+
+	tx, receipt, err := s.sendTx(ctx, sw.FromWallet, func() (*types.Transaction, error) {
+		return s.buildSwap(ctx, sw, armed)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getSwapResult(ctx, sw, tx, receipt)
+}
+
+// executeSwaps runs the swaps of several wallets concurrently and returns
+// the results of those that went through, failing only when none did.
+func (s *sniper) executeSwaps(swaps []*swap.DexSwap) ([]*swapResult, error) {
+	results := make([]*swapResult, len(swaps))
+	errs := make([]error, len(swaps))
+	var wg sync.WaitGroup
+	for i, sw := range swaps {
+		wg.Add(1)
+		go func(i int, sw *swap.DexSwap) {
+			defer wg.Done()
+			results[i], errs[i] = s.executeSwap(sw, nil)
+		}(i, sw)
+	}
+	wg.Wait()
+
+	var done []*swapResult
+	for i, err := range errs {
+		if err != nil {
+			if len(swaps) > 1 {
+				log.Printf("Swap of wallet %s failed: %s", swaps[i].FromWallet.Address().Hex(), err)
+			}
+			continue
+		}
+		done = append(done, results[i])
+	}
+	if len(done) == 0 {
+		return nil, errs[0]
+	}
+	return done, nil
+}
+
+// executeBundledSwap backruns targetTx with the swap, sending both to the
+// relay as a bundle so the swap lands right after it in the same block.
+func (s *sniper) executeBundledSwap(relay *eth.BundleRelay, sw *swap.DexSwap, armed *swap.ArmedSwap, targetTx *types.Transaction) (*swapResult, error) {
+	ctx := context.Background()
+
+	tx, err := s.buildSwap(ctx, sw, armed)
+	if err != nil {
+		return nil, err
+	}
+
+	// the swap never reaches the mempool, its nonce stays reserved until the
+	// bundle lands or is given up
+	receipt, err := relay.SendUntilIncluded(ctx, s.network.Client(), []*types.Transaction{targetTx, tx})
+	if err != nil {
+		s.wallet.Nonces.Release(tx.Nonce())
+		return nil, fmt.Errorf("Failed to get bundle included: %w", err)
+	}
+	s.wallet.Nonces.Sent(tx.Nonce())
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return nil, fmt.Errorf("Transaction %s reverted", tx.Hash().Hex())
+	}
+	log.Printf("Transaction mined: %s\n", tx.Hash().Hex())
+
+	return s.getSwapResult(ctx, sw, tx, receipt)
+}
+
+// getSwapResult reads the amounts the wallet actually sent and received, which
+// differ from those the pairs swapped for taxed tokens. Coins never show up in
+// token transfers, their amounts are taken from the pairs.
+func (s *sniper) getSwapResult(ctx context.Context, sw *swap.DexSwap, tx *types.Transaction, receipt *types.Receipt) (*swapResult, error) {
+	amountIn, amountOut, err := swap.GetSwapAmounts(receipt)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get swapped amounts: %s", err)
+	}
+	wallet := sw.FromWallet
+	if sent := swap.GetSentAmount(receipt, sw.TokenIn.Address, wallet.Address()); sent.Sign() > 0 {
+		amountIn = sent
+	}
+	if received := swap.GetReceivedAmount(receipt, sw.TokenOut.Address, wallet.Address()); received.Sign() > 0 {
+		amountOut = received
+	}
+
+	gasFees, err := eth.GetTxGasFees(s.network.Client(), ctx, tx, receipt)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get gas fees: %s", err)
+	}
+
+	res := &swapResult{
+		Wallet:    wallet,
+		AmountIn:  amountIn,
+		AmountOut: amountOut,
+		GasFees:   gasFees,
+		Tx:        tx,
+		Block:     receipt.BlockNumber.Uint64(),
+	}
+	return res, nil
+}
+
+func (s *sniper) approveSwap(sw *swap.DexSwap) (*big.Int, error) {
+	ctx := context.Background()
+
+	// positions resumed from the ledger were usually approved before
+	needed, err := sw.NeedsApproval(ctx, s.dex.RouterContract.Address)
+	if err != nil {
+		return nil, err
+	}
+	if !needed {
+		log.Printf("Router is already allowed to spend %.18f %s", eth.FromWei(sw.AmountIn, params.Ether), sw.TokenIn.Symbol)
+		return big.NewInt(0), nil
 	}
 
-	receipt, err := bind.WaitMined(ctx, client, tx)
+	tx, receipt, err := s.sendTx(ctx, sw.FromWallet, func() (*types.Transaction, error) {
+		tx, err := sw.BuildApproveTx(s.network.Client(), ctx, s.dex.RouterContract.Address)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to build approve transaction: %s", err)
+		}
+		return tx, nil
+	})
 	if err != nil {
-		log.Printf("Error waiting for transaction mining: %s\n", err)
+		return nil, err
+	}
+	log.Printf("Approved router to spend %.18f %s", eth.FromWei(sw.AmountIn, params.Ether), sw.TokenIn.Symbol)
+
+	gasFees, err := eth.GetTxGasFees(s.network.Client(), ctx, tx, receipt)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get gas fees: %s", err)
+	}
+	s.record(&ledger.Event{
+		Kind:     ledger.ApproveEvent,
+		Wallet:   sw.FromWallet.Address(),
+		Token:    sw.TokenIn.Address,
+		TxHash:   tx.Hash(),
+		Block:    receipt.BlockNumber.Uint64(),
+		AmountIn: sw.AmountIn,
+		GasFees:  gasFees,
+	})
+	return gasFees, nil
+}
+
+// approveSwaps approves the swaps of several wallets concurrently and returns
+// the fees paid along with the swaps approved, failing only when none was.
+func (s *sniper) approveSwaps(swaps []*swap.DexSwap) (*big.Int, []*swap.DexSwap, error) {
+	fees := make([]*big.Int, len(swaps))
+	errs := make([]error, len(swaps))
+	var wg sync.WaitGroup
+	for i, sw := range swaps {
+		wg.Add(1)
+		go func(i int, sw *swap.DexSwap) {
+			defer wg.Done()
+			fees[i], errs[i] = s.approveSwap(sw)
+		}(i, sw)
+	}
+	wg.Wait()
+
+	total := big.NewInt(0)
+	var approved []*swap.DexSwap
+	for i, err := range errs {
+		if err != nil {
+			if len(swaps) > 1 {
+				log.Printf("Approval of wallet %s failed: %s", swaps[i].FromWallet.Address().Hex(), err)
+			}
+			continue
+		}
+		total.Add(total, fees[i])
+		approved = append(approved, swaps[i])
+	}
+	if len(approved) == 0 {
+		return nil, nil, errs[0]
+	}
+	return total, approved, nil
+}
+
+// consolidate sends the whole balance of the token held by the wallets to the
+// main wallet, or their coins when token is nil.
+func (s *sniper) consolidate(ctx context.Context, wallets []*eth.Wallet, token *eth.Token) {
+	symbol := s.conf.EthSymbol
+	if token != nil {
+		symbol = token.Symbol
+	}
+
+	var wg sync.WaitGroup
+	for _, w := range wallets {
+		if w == s.wallet {
+			continue
+		}
+		wg.Add(1)
+		go func(w *eth.Wallet) {
+			defer wg.Done()
+			if token != nil {
+				// wallets of the pool left unused hold nothing to send
+				balance, err := token.BalanceOf(&bind.CallOpts{Context: ctx}, w.Address())
+				if err == nil && balance.Sign() == 0 {
+					return
+				}
+			}
+			tx, receipt, err := s.sendTx(ctx, w, func() (*types.Transaction, error) {
+				if token == nil {
+					return w.BuildSweepTx(s.network.Client(), ctx, s.wallet.Address(), s.conf.GasStrategy)
+				}
+				balance, err := token.BalanceOf(&bind.CallOpts{Context: ctx}, w.Address())
+				if err != nil {
+					return nil, fmt.Errorf("Failed to get %s balance: %s", token.Symbol, err)
+				}
+				return w.BuildTokenTransferTx(s.network.Client(), ctx, token, s.wallet.Address(), balance, s.conf.GasStrategy)
+			})
+			if err != nil {
+				log.Printf("Failed to consolidate %s of %s: %s", symbol, w.Address().Hex(), err)
+				return
+			}
+			log.Printf("Consolidated %s of %s into %s", symbol, w.Address().Hex(), s.wallet.Address().Hex())
+			s.recordTransfer(ctx, w, token, tx, receipt)
+		}(w)
+	}
+	wg.Wait()
+}
+
+// approveInput lets the router spend the input token of the wallets buying
+// with the swap, without limit so that concurrent buys need no approval of
+// their own.
+func (s *sniper) approveInput(sw *swap.DexSwap, wallets []*eth.Wallet) error {
+	var approvals []*swap.DexSwap
+	for _, w := range wallets {
+		approval := *sw
+		approval.FromWallet = w
+		needed, err := approval.NeedsApproval(context.Background(), s.dex.RouterContract.Address)
+		if err != nil {
+			return err
+		}
+		if needed {
+			approval.AmountIn = abi.MaxUint256
+			approvals = append(approvals, &approval)
+		}
+	}
+	if len(approvals) == 0 {
+		return nil
+	}
+
+	_, approved, err := s.approveSwaps(approvals)
+	if err != nil {
+		return err
+	}
+	if len(approved) < len(approvals) {
+		return fmt.Errorf("only %d of %d wallets approved", len(approved), len(approvals))
+	}
+	return nil
+}
+
+// route takes the path quoting best through the base tokens, keeping the
+// current one when none quotes, as before the liquidity of the target is
+// mined.
+func (s *sniper) route(ctx context.Context, sw *swap.DexSwap, bases []common.Address) {
+	path, amountOut, err := s.dex.FindBestPath(ctx, sw.AmountIn, sw.TokenIn.Address, sw.TokenOut.Address, bases)
+	if err != nil {
+		log.Printf("Keeping path %s to swap %s: %s", swap.FormatPath(sw.Path()), sw.TokenIn.Symbol, err)
+		return
+	}
+	log.Printf(
+		"Routing swap of %s through %s, quoted %.18f %s",
+		sw.TokenIn.Symbol, swap.FormatPath(path), eth.FromWei(amountOut, params.Ether), sw.TokenOut.Symbol,
+	)
+	sw.Route = path
+}
+
+// quoteExactOutput sets the most the exact-output swap spends. The direct pair
+// is quoted against the reserves liquidityTx leaves, which the router cannot
+// see while it is pending.
+func (s *sniper) quoteExactOutput(ctx context.Context, sw *swap.DexSwap, liquidityTx *types.Transaction) error {
+	var quote *big.Int
+	var err error
+	if liquidityTx != nil && len(sw.Path()) == 2 {
+		var reserveIn, reserveOut *big.Int
+		reserveIn, reserveOut, err = s.dex.GetExpectedReserves(s.network.Client(), ctx, sw.TokenIn.Address, sw.TokenOut.Address, liquidityTx)
+		if err != nil {
+			return fmt.Errorf("Failed to get expected reserves: %s", err)
+		}
+		quote, err = s.dex.GetAmountIn(sw.AmountOut, reserveIn, reserveOut)
 	} else {
-		log.Printf("Transaction mined: %s\n", tx.Hash().Hex())
+		quote, err = sw.QuoteAmountIn(s.dex.Router, ctx)
+	}
+	if err != nil {
+		return err
 	}
+	return sw.LimitAmountIn(quote)
+}
 
-	tokensBought := eth.FromWei(new(big.Int), params.Ether)
-	buyPrice := new(big.Float)
+// quoteExactSells shares amountOut across the sells by the size of their
+// positions, and limits the tokens each one sends to its quote.
+func (s *sniper) quoteExactSells(ctx context.Context, sells []*swap.DexSwap, amountOut *big.Int) error {
+	total := big.NewInt(0)
+	for _, sw := range sells {
+		total.Add(total, sw.AmountIn)
+	}
+	for _, sw := range sells {
+		sw.AmountOut = new(big.Int).Mul(amountOut, sw.AmountIn)
+		sw.AmountOut.Div(sw.AmountOut, total)
+		err := s.quoteExactOutput(ctx, sw, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// routeBases are the tokens swaps of the target may be routed through, the
+// pair token first.
+func (s *sniper) routeBases(target *config.Target) []common.Address {
+	bases := s.conf.BaseTokens
+	if target.PairToken != s.inToken.Address {
+		bases = append([]common.Address{target.PairToken}, bases...)
+	}
+	return bases
+}
+
+// resumedBuys are the positions on the target a previous run left open in the
+// wallets configured.
+func (s *sniper) resumedBuys(targetToken *eth.Token) []*swapResult {
+	var buys []*swapResult
+	for _, p := range s.positions {
+		if p.Token != targetToken.Address {
+			continue
+		}
+		var wallet *eth.Wallet
+		for _, w := range s.wallets {
+			if w.Address() == p.Wallet {
+				wallet = w
+			}
+		}
+		if wallet == nil {
+			log.Printf("Position on %s is held by wallet %s which is not configured", targetToken.Symbol, p.Wallet.Hex())
+			continue
+		}
+		log.Printf(
+			"Resuming position of %.18f %s held by %s since %s",
+			eth.FromWei(p.Amount, params.Ether), targetToken.Symbol, p.Wallet.Hex(), p.OpenedAt,
+		)
+		buys = append(buys, &swapResult{Wallet: wallet, AmountIn: p.Cost, AmountOut: p.Amount, GasFees: p.GasFees})
+	}
+	return buys
+}
+
+// buy spends the part of the budget granted to the swap, giving back what
+// was left unspent. With a relay the swap backruns liquidityTx in a bundle,
+// and with several wallets it is split across them, each wallet holding its
+// part of the position. With probeLimits each part is kept under the limits
+// of the token for its own wallet.
+func (s *sniper) buy(sw *swap.DexSwap, armed *swap.ArmedSwap, relay *eth.BundleRelay, liquidityTx *types.Transaction, wallets []*eth.Wallet, probeLimits bool) ([]*swapResult, error) {
+	granted := s.budget.Reserve(sw.AmountIn)
+	if granted.Sign() == 0 {
+		return nil, errors.New("buy budget exhausted")
+	}
+	if granted.Cmp(sw.AmountIn) < 0 {
+		log.Printf(
+			"Scaling buy of %s down from %.18f to %.18f %s to stay within budget",
+			sw.TokenOut.Symbol, eth.FromWei(sw.AmountIn, params.Ether), eth.FromWei(granted, params.Ether), sw.TokenIn.Symbol,
+		)
+		sw.ScaleAmountIn(granted)
+	}
+
+	swaps := []*swap.DexSwap{sw}
+	if len(wallets) > 1 {
+		swaps = sw.Split(wallets)
+	}
+	if probeLimits {
+		for _, part := range swaps {
+			err := part.LimitToTokenLimits(s.network.Client(), context.Background(), s.dex.Router)
+			if err != nil {
+				log.Printf("Failed to probe limits of %s for %s, buying as configured: %s", sw.TokenOut.Symbol, part.FromWallet.Address().Hex(), err)
+			}
+		}
+	}
+
+	var parts []*swapResult
+	var err error
+	if len(wallets) > 1 {
+		parts, err = s.executeSwaps(swaps)
+	} else {
+		var res *swapResult
+		if relay != nil {
+			res, err = s.buyBundled(sw, armed, relay, liquidityTx)
+		} else {
+			res, err = s.executeSwap(sw, armed)
+		}
+		parts = []*swapResult{res}
+	}
+	if err != nil {
+		s.budget.Release(granted)
+		return nil, err
+	}
+
+	s.budget.Release(new(big.Int).Sub(granted, mergeResults(parts).AmountIn))
+	return parts, nil
+}
+
+func (s *sniper) buyBundled(sw *swap.DexSwap, armed *swap.ArmedSwap, relay *eth.BundleRelay, liquidityTx *types.Transaction) (*swapResult, error) {
+	res, err := s.executeBundledSwap(relay, sw, armed, liquidityTx)
+	if errors.Is(err, eth.ErrBundleTargetMined) {
+		log.Printf("Liquidity was added without the bundle, buying %s through the mempool", sw.TokenOut.Symbol)
+		sw.AmountOutQuote = nil
+		// the pre-signed swap went into the bundle, its nonce was given back
+		return s.executeSwap(sw, nil)
+	}
+	return res, err
+}
+
+func (s *sniper) snipe(target *config.Target) error {
+	var err error
+	ctx := context.Background()
+	conf := s.conf
+
+	targetToken, err := eth.NewToken(s.reads, target.Addr)
+	if err != nil {
+		return fmt.Errorf("Failed to setup target Token: %s", err)
+	}
 
-	gasUsed := new(big.Int).SetUint64(receipt.GasUsed)
-	totalFee := new(big.Int).Mul(gasUsed, tx.GasPrice())
+	// outbid strategies follow the liquidity transaction of their own target
+	gasStrategy := conf.BuyGasStrategy
+	outbid, isOutbid := conf.BuyGasStrategy.(*eth.OutbidGas)
+	if isOutbid {
+		own := *outbid
+		outbid = &own
+		gasStrategy = outbid
+	}
+
+	buyFunc := swap.ExactEthForTokens
+	if conf.InTokenIsErc20 {
+		buyFunc = swap.ExactTokensForTokens
+	}
+	if target.TokenAmount != nil {
+		buyFunc = swap.EthForExactTokens
+		if conf.InTokenIsErc20 {
+			buyFunc = swap.TokensForExactTokens
+		}
+	}
+	bases := s.routeBases(target)
+
+	buySwap := &swap.DexSwap{
+		FromWallet:  s.wallet,
+		SwapFunc:    buyFunc,
+		TokenOut:    targetToken,
+		TokenIn:     s.inToken,
+		AmountIn:    target.BuyAmount,
+		AmountOut:   target.TokenAmount,
+		SlippageBps: target.BuySlippageBps,
+		GasStrategy: gasStrategy,
+		GasLimit:    conf.SwapGasLimit,
+		Expiration:  big.NewInt(60 * 60),
+	}
+	if target.PairToken != s.inToken.Address {
+		buySwap.Route = []common.Address{s.inToken.Address, target.PairToken, targetToken.Address}
+	}
+	if buys := s.resumedBuys(targetToken); len(buys) > 0 {
+		return s.sell(ctx, target, targetToken, buySwap, buys)
+	}
+	if conf.InTokenIsErc20 {
+		err = s.approveInput(buySwap, s.wallets[:target.BuyWallets])
+		if err != nil {
+			return fmt.Errorf("Failed to approve router to spend %s: %s", s.inToken.Symbol, err)
+		}
+	}
+	if target.BuyTrigger.Mode == triggers.TradingEnabledMode {
+		// trading is probed with a small exact-input buy
+		probe := *buySwap
+		probe.AmountOut = nil
+		probe.SwapFunc = swap.ExactEthForTokens
+		if conf.InTokenIsErc20 {
+			probe.SwapFunc = swap.ExactTokensForTokens
+		}
+		target.BuyTrigger.ProbeSwap = &probe
+	}
+
+	var relay *eth.BundleRelay
+	if target.BundleRelayUrl != "" {
+		relay, err = eth.NewBundleRelay(ctx, target.BundleRelayUrl)
+		if err != nil {
+			return fmt.Errorf("Failed to setup bundle relay: %s", err)
+		}
+		relay.MaxBlocks = target.BundleMaxBlocks
+		log.Printf("Buy of %s will be bundled through relay at %s", targetToken.Symbol, relay.Url)
+	}
+
+	var armed *swap.ArmedSwap
+	if target.PreArm {
+		if len(bases) > 0 {
+			s.route(ctx, buySwap, bases)
+		}
+		// the pair cannot be quoted before liquidity is added
+		if target.MaxBuyPrice != nil {
+			buySwap.AmountOutMin = swap.MinAmountOutAtPrice(buySwap.AmountIn, target.MaxBuyPrice)
+		}
+		armed, err = swap.ArmSwap(s.network.Client(), s.dex.Router, buySwap)
+		if err != nil {
+			return err
+		}
+		defer armed.Disarm()
+	}
+
+	if target.SimulateTrade {
+		target.BuyTrigger.Simulator, err = swap.NewTradeSimulator(s.client, s.geth, s.dex, buySwap)
+		if err != nil {
+			return fmt.Errorf("Failed to setup trade simulation: %s", err)
+		}
+	}
+
+	target.BuyTrigger.Network = s.network
+	liquidityTx, fired := <-target.BuyTrigger.Set(s.client, s.mempool, s.dex, targetToken)
+	if armed != nil {
+		armed.Disarm()
+	}
+	if !fired {
+		return errors.New("Buy was vetoed or its trigger failed")
+	}
+	if isOutbid {
+		outbid.Target = liquidityTx
+	}
+	// there is nothing to backrun when the trigger fired without a transaction
+	if liquidityTx == nil {
+		relay = nil
+	}
+	// the quotes below and the pre-signed swap go along the chosen path
+	if len(bases) > 0 {
+		s.route(ctx, buySwap, bases)
+	}
+	// a pre-signed swap keeps its max input, the quote would sign it again
+	if buySwap.AmountOut != nil && armed == nil {
+		err = s.quoteExactOutput(ctx, buySwap, liquidityTx)
+		if err != nil {
+			return fmt.Errorf("Refusing to buy: %s", err)
+		}
+	}
+	if target.MaxBuyPrice != nil {
+		reserveIn, reserveOut, err := s.dex.GetExpectedReserves(s.network.Client(), ctx, s.inToken.Address, targetToken.Address, liquidityTx)
+		if err != nil {
+			return fmt.Errorf("Failed to get reserves to check max buy price: %s", err)
+		}
+		err = buySwap.LimitToMaxPrice(s.dex, reserveIn, reserveOut, target.MaxBuyPrice, target.ScaleBuyToMaxPrice)
+		if err != nil {
+			return fmt.Errorf("Refusing to buy: %s", err)
+		}
+	}
+
+	// the router cannot quote a pair whose liquidity is still pending
+	if liquidityTx != nil && buySwap.AmountOut == nil && buySwap.AmountOutMin == nil {
+		buySwap.AmountOutQuote, err = s.dex.QuotePendingPath(s.network.Client(), ctx, buySwap.AmountIn, buySwap.Path(), liquidityTx)
+		if err != nil {
+			return fmt.Errorf("Failed to quote buy from pending liquidity: %s", err)
+		}
+	}
+
+	buys, err := s.buy(buySwap, armed, relay, liquidityTx, s.wallets[:target.BuyWallets], target.ProbeLimits)
+	if err != nil {
+		return fmt.Errorf("Failed to buy tokens: %s", err)
+	}
+	s.recordSwaps(ledger.BuyEvent, targetToken.Address, buys)
+
+	return s.sell(ctx, target, targetToken, buySwap, buys)
+}
+
+// sell holds the positions bought with buySwap until the sell trigger fires,
+// and then sells them along the path they were bought through.
+func (s *sniper) sell(ctx context.Context, target *config.Target, targetToken *eth.Token, buySwap *swap.DexSwap, buys []*swapResult) error {
+	conf := s.conf
+	sellFunc := swap.ExactTokensForEth
+	if conf.InTokenIsErc20 {
+		sellFunc = swap.ExactTokensForTokens
+	}
+	if target.SellAmount != nil {
+		sellFunc = swap.TokensForExactEth
+		if conf.InTokenIsErc20 {
+			sellFunc = swap.TokensForExactTokens
+		}
+	}
+	bases := s.routeBases(target)
+
+	buy := mergeResults(buys)
+
+	buyPrice, err := eth.TokenRatio(buy.AmountIn, buy.AmountOut)
+	if err != nil {
+		return fmt.Errorf("Failed to get buy price: %s", err)
+	}
+	log.Printf(
+		`Bought %.18f %s for %.18f %s across %d wallets
+			Buy price: %.18f %s per %s
+			Gas fees: %.18f %s`,
+		eth.FromWei(buy.AmountOut, params.Ether), targetToken.Symbol, eth.FromWei(buy.AmountIn, params.Ether), conf.EthSymbol, len(buys),
+		buyPrice, conf.EthSymbol, targetToken.Symbol,
+		eth.FromWei(buy.GasFees, params.Ether), conf.EthSymbol,
+	)
+
+	var sellSwaps []*swap.DexSwap
+	for _, part := range buys {
+		// fee-on-transfer tokens deliver less than the pair sent out
+		position := part.AmountOut
+		balance, err := targetToken.BalanceOf(&bind.CallOpts{Context: ctx}, part.Wallet.Address())
+		if err != nil {
+			log.Printf("Failed to get %s balance, selling swapped amount: %s\n", targetToken.Symbol, err)
+		} else if balance.Cmp(position) < 0 {
+			log.Printf("Received %.18f %s after transfer fees", eth.FromWei(balance, params.Ether), targetToken.Symbol)
+			position = balance
+		}
+		if position.Sign() == 0 {
+			log.Printf("No %s left in wallet %s to sell", targetToken.Symbol, part.Wallet.Address().Hex())
+			continue
+		}
+
+		sellSwaps = append(sellSwaps, &swap.DexSwap{
+			FromWallet:  part.Wallet,
+			SwapFunc:    sellFunc,
+			TokenIn:     targetToken,
+			TokenOut:    s.inToken,
+			AmountIn:    position,
+			SlippageBps: target.SellSlippageBps,
+			GasStrategy: conf.GasStrategy,
+			Expiration:  big.NewInt(60 * 60),
+			Route:       swap.ReversePath(buySwap.Path()),
+		})
+	}
+
+	if len(sellSwaps) == 0 {
+		return fmt.Errorf("No %s left to sell", targetToken.Symbol)
+	}
+
+	approveFees, sellSwaps, err := s.approveSwaps(sellSwaps)
+	if err != nil {
+		return fmt.Errorf("Failed to approve router: %s", err)
+	}
+
+	buyPath := buySwap.Path()
+	pricer, err := swap.NewRoutePriceWatcher(s.reads, s.dex, ctx, s.inToken, targetToken, buyPath[1:len(buyPath)-1], true)
+	if err != nil {
+		return fmt.Errorf("Failed to setup target token price watchers: %s", err)
+	}
+
+	reason := <-target.SellTrigger.Set(buyPrice, pricer.Prices())
+	if len(bases) > 0 {
+		for _, sellSwap := range sellSwaps {
+			s.route(ctx, sellSwap, bases)
+		}
+	}
+	if target.SellAmount != nil {
+		err = s.quoteExactSells(ctx, sellSwaps, target.SellAmount)
+		if err != nil {
+			return fmt.Errorf("Refusing to sell: %s", err)
+		}
+	}
+	sells, err := s.executeSwaps(sellSwaps)
+	if err != nil {
+		return fmt.Errorf("Failed to sell tokens: %s", err)
+	}
+	s.recordSwaps(ledger.SellEvent, targetToken.Address, sells)
+	sell := mergeResults(sells)
+
+	gasFees := new(big.Int).Add(buy.GasFees, approveFees)
+	gasFees.Add(gasFees, sell.GasFees)
+	dexFees := new(big.Int).Add(s.dex.FeeFromAmountIn(buy.AmountIn), s.dex.FeeFromAmountOut(sell.AmountOut))
+	pnl := new(big.Int).Sub(sell.AmountOut, buy.AmountIn)
+	inSymbol := conf.EthSymbol
+	if conf.InTokenIsErc20 {
+		// gas is paid in coins, which do not add up with the input token
+		inSymbol = s.inToken.Symbol
+	} else {
+		pnl.Sub(pnl, gasFees)
+	}
 
 	log.Printf(
-		`Sniped %f %s at %f %s
-			Spent: %f %s
-			Fees: %f %s
-			Total cost: %f %s`,
-		tokensBought, sw.TokenOut.Symbol, buyPrice, "BNB",
-		eth.FromWei(tx.Value(), params.Ether), "BNB",
-		eth.FromWei(totalFee, params.Ether), "BNB",
-		eth.FromWei(tx.Cost(), params.Ether), "BNB",
+		`Position on %s closed on %s
+			Bought %.18f %s for %.18f %s
+			Sold %.18f %s for %.18f %s
+			Gas fees: %.18f %s (buy %.18f, approve %.18f, sell %.18f)
+			Dex fees: %.18f %s
+			Realized PnL: %.18f %s`,
+		targetToken.Symbol, reason,
+		eth.FromWei(buy.AmountOut, params.Ether), targetToken.Symbol, eth.FromWei(buy.AmountIn, params.Ether), inSymbol,
+		eth.FromWei(sell.AmountIn, params.Ether), targetToken.Symbol, eth.FromWei(sell.AmountOut, params.Ether), inSymbol,
+		eth.FromWei(gasFees, params.Ether), conf.EthSymbol,
+		eth.FromWei(buy.GasFees, params.Ether), eth.FromWei(approveFees, params.Ether), eth.FromWei(sell.GasFees, params.Ether),
+		eth.FromWei(dexFees, params.Ether), inSymbol,
+		eth.FromWei(pnl, params.Ether), inSymbol,
 	)
 
-	return receipt, nil
+	if conf.Consolidate && len(buys) > 1 {
+		var unsold []*eth.Wallet
+		for _, part := range buys {
+			if !soldBy(sells, part.Wallet) {
+				unsold = append(unsold, part.Wallet)
+			}
+		}
+		// tokens a failed sell left behind are gathered in the main wallet
+		s.consolidate(ctx, unsold, targetToken)
+	}
+	return nil
+}
+
+func soldBy(sells []*swapResult, wallet *eth.Wallet) bool {
+	for _, sell := range sells {
+		if sell.Wallet == wallet {
+			return true
+		}
+	}
+	return false
 }
 
 func main() {
@@ -71,73 +905,154 @@ func main() {
 		log.Fatalf("Failed to read configuration file: %s", err)
 	}
 
-	network := &eth.Network{RpcUrl: conf.RpcUrl}
+	network := &eth.Network{RpcUrl: conf.RpcUrl, ExtraUrls: conf.ExtraRpcUrls}
 	client, err := network.Connect(ctx)
 	if (err != nil) || (!network.IsConnected()) {
 		log.Fatalf("Failed to connect to network: %s\n", err)
 	}
 	log.Printf("Connected to network via RPC node at %s", network.RpcUrl)
 
-	rpcCon, err := rpc.Dial(conf.RpcUrl)
-	if err != nil {
-		log.Fatalf("Failed to connect to RPC Node: %s\n", err)
+	geth := gethclient.New(network.Rpc())
+	if percentile, ok := conf.GasStrategy.(*eth.PercentileGas); ok {
+		percentile.Rpc = network.Rpc()
 	}
-	geth := gethclient.New(rpcCon)
+	go func() {
+		for state := range network.States() {
+			log.Printf("Connection to RPC node %s", state)
+		}
+	}()
 
-	wallet, err := eth.NewWallet(conf.PrivateKey, conf.ChainID)
+	reads := &eth.ReadBackend{ContractBackend: client, Reads: network}
+	inToken, err := eth.NewToken(reads, conf.InTokenAddr)
 	if err != nil {
-		log.Fatalf("Failed to instantiate Wallet: %s\n", err.Error())
+		log.Fatalf("Failed to instantiate input Token: %s\n", err)
 	}
-	log.Printf("Using wallet of address %s", wallet.Address())
 
-	ethBalance, err := wallet.GetEthBalance(client, ctx, params.Ether)
-	if err != nil {
-		log.Fatalf("Failed to get %s balance: %s", conf.EthSymbol, err)
+	inSymbol := conf.EthSymbol
+	if conf.InTokenIsErc20 {
+		inSymbol = inToken.Symbol
 	}
-	log.Printf("Current %s balance: %f \n", conf.EthSymbol, ethBalance)
 
-	inToken, err := eth.NewToken(client, conf.InTokenAddr)
+	events, err := ledger.ReadEvents(conf.LedgerPath)
 	if err != nil {
-		log.Fatalf("Failed to instantiate input Token: %s\n", err)
+		log.Fatalf("Failed to read ledger: %s\n", err)
 	}
-
-	targetToken, err := eth.NewToken(client, conf.TargetTokenAddr)
+	positions := ledger.OpenPositions(events)
+	book, err := ledger.Open(conf.LedgerPath)
 	if err != nil {
-		log.Fatalf("Failed to setup target Token: %s\n", err)
+		log.Fatalf("Failed to setup ledger: %s\n", err)
 	}
+	defer book.Close()
+	log.Printf("Recording to ledger %s, %d positions left open", conf.LedgerPath, len(positions))
 
-	dex, err := swap.SetupDex(client, conf.FactoryAddress, conf.RouterAddress)
-	if err != nil {
-		log.Fatalf("Failed to setup dex client: %s\n", err)
+	var wallets []*eth.Wallet
+	txms := make(map[common.Address]*eth.TxManager)
+	balance := big.NewInt(0)
+	for _, src := range append([]eth.WalletSource{conf.Wallet}, conf.WalletPool...) {
+		wallet, err := eth.OpenWallet(src, conf.ChainID)
+		if err != nil {
+			log.Fatalf("Failed to instantiate Wallet: %s\n", err.Error())
+		}
+		log.Printf("Using wallet of address %s", wallet.Address())
+
+		err = wallet.Nonces.Sync(client, ctx)
+		if err != nil {
+			log.Fatalf("Failed to sync wallet nonce: %s\n", err)
+		}
+		go wallet.Nonces.Watch(client, ctx, eth.DefaultNonceCheckInterval)
+
+		var walletBalance *big.Int
+		if conf.InTokenIsErc20 {
+			walletBalance, err = inToken.BalanceOf(&bind.CallOpts{Context: ctx}, wallet.Address())
+		} else {
+			walletBalance, err = client.BalanceAt(ctx, wallet.Address(), nil)
+		}
+		if err != nil {
+			log.Fatalf("Failed to get %s balance: %s", inSymbol, err)
+		}
+		log.Printf("Current %s balance: %f \n", inSymbol, eth.FromWei(walletBalance, params.Ether))
+		balance.Add(balance, walletBalance)
+
+		txm := eth.NewTxManager(client, wallet)
+		txm.BumpAfterBlocks = conf.BumpAfterBlocks
+		txm.BumpPercent = conf.BumpPercent
+		txm.MaxBumps = conf.MaxBumps
+		txm.CancelStuck = conf.CancelStuck
+		txm.GasCap = conf.GasStrategy.GasCap()
+		txm.Sender = network
+		txm.Reads = network
+		txms[wallet.Address()] = txm
+		wallets = append(wallets, wallet)
 	}
 
-	pricer, err := swap.NewPriceWatcher(client, dex, ctx, targetToken, inToken, true)
+	budget := balance
+	if conf.Budget != nil && conf.Budget.Cmp(balance) < 0 {
+		budget = conf.Budget
+	}
+	log.Printf("Buying up to %f %s across %d targets", eth.FromWei(budget, params.Ether), inSymbol, len(conf.Targets))
+
+	dex, err := swap.SetupDex(reads, conf.FactoryAddress, conf.RouterAddress)
 	if err != nil {
-		log.Fatalf("Failed to setup target token price watchers: %s\n", err)
+		log.Fatalf("Failed to setup dex client: %s\n", err)
 	}
+	dex.FeeBps = conf.DexFeeBps
 
-	buySwap := &swap.DexSwap{
-		FromWallet:  wallet,
-		SwapFunc:    swap.ExactEthForTokens,
-		TokenOut:    targetToken,
-		TokenIn:     inToken,
-		AmountIn:    conf.InTokenBuyAmount,
-		GasStrategy: "fast",
-		Expiration:  big.NewInt(60 * 60),
+	s := &sniper{
+		conf:    conf,
+		network: network,
+		client:  client,
+		reads:   reads,
+		geth:    geth,
+		wallet:  wallets[0],
+		wallets: wallets,
+		txms:    txms,
+		dex:     dex,
+		inToken: inToken,
+		mempool: triggers.NewMempoolHub(network, client, dex.RouterContract.ABI),
+		budget:  swap.NewBudget(budget),
+		ledger:  book,
+
+		positions: positions,
+	}
+	for addr, txm := range txms {
+		wallet := addr
+		txm.OnReplace = func(replaced, replacement *types.Transaction) {
+			hash := replaced.Hash()
+			s.record(&ledger.Event{
+				Kind:     ledger.ReplacementEvent,
+				Wallet:   wallet,
+				TxHash:   replacement.Hash(),
+				Cost:     replacement.Value(),
+				Replaced: &hash,
+			})
+		}
 	}
 
-	<-conf.BuyTrigger.Set(client, geth, dex, targetToken)
-	_, err = buyTokens(client, buySwap, dex, pricer)
-	if err != nil {
-		log.Fatalf("Failed to buy tokens: %s\n", err)
+	var wg sync.WaitGroup
+	for _, target := range conf.Targets {
+		wg.Add(1)
+		go func(target *config.Target) {
+			defer wg.Done()
+			err := s.snipe(target)
+			if err != nil {
+				log.Printf("Failed to snipe %s: %s\n", target.Addr.Hex(), err)
+			}
+		}(target)
 	}
+	wg.Wait()
 
-	// sellSwap := &swap.DexSwap{
-	// }
+	// the pool wallets are swept only once every target is done with them
+	if conf.Consolidate && len(wallets) > 1 {
+		if conf.InTokenIsErc20 {
+			s.consolidate(ctx, wallets, inToken)
+		}
+		s.consolidate(ctx, wallets, nil)
+	}
 
-	// <-conf.SellTrigger.Set(client, geth, dex, inToken, tokenPrices)
-	// _, err = buyTokens(client, sellSwap, dex, targetToken)
-	// if err != nil {
-	// 	log.Fatalf("Failed to sell tokens: %s\n", err)
-	// }
+	for _, stats := range network.Stats() {
+		log.Printf(
+			"RPC endpoint %s: %d requests, %d errors, %d first acknowledgements, latency %s",
+			stats.Url, stats.Requests, stats.Errors, stats.FirstAcks, stats.Latency,
+		)
+	}
 }
@@ -9,6 +9,7 @@ import (
 	pancake "sniper/contracts/bsc/pancakeswap"
 	"sniper/pkg/config"
 	eth "sniper/pkg/eth"
+	"sniper/pkg/ledger"
 	"sniper/pkg/swap"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
@@ -34,6 +35,9 @@ func main() {
 		log.Fatalf("Failed to connect to network: %s\n", err)
 	}
 	log.Printf("Connected to network via RPC node at %s", network.RpcUrl)
+	if percentile, ok := conf.GasStrategy.(*eth.PercentileGas); ok {
+		percentile.Rpc = network.Rpc()
+	}
 
 	rpcCon, err := rpc.Dial(conf.RpcUrl)
 	if err != nil {
@@ -41,7 +45,7 @@ func main() {
 	}
 	_ = gethclient.New(rpcCon)
 
-	wallet, err := eth.NewWallet(conf.PrivateKey, conf.ChainID)
+	wallet, err := eth.OpenWallet(conf.Wallet, conf.ChainID)
 	if err != nil {
 		log.Fatalf("Failed to instantiate Wallet: %s\n", err.Error())
 	}
@@ -58,7 +62,7 @@ func main() {
 		log.Fatalf("Failed to instantiate input Token: %s\n", err)
 	}
 
-	targetToken, err := eth.NewToken(client, conf.TargetTokenAddr)
+	targetToken, err := eth.NewToken(client, conf.Targets[0].Addr)
 	if err != nil {
 		log.Fatalf("Failed to setup target Token: %s\n", err)
 	}
@@ -77,19 +81,25 @@ func main() {
 		FromWallet:  wallet,
 		SwapFunc:    swap.ExactEthForTokens,
 		TokenIn:     inToken,
-		AmountIn:    conf.InTokenBuyAmount,
+		AmountIn:    conf.Targets[0].BuyAmount,
 		TokenOut:    targetToken,
-		GasStrategy: "fast",
+		GasStrategy: conf.BuyGasStrategy,
 		Expiration:  big.NewInt(60 * 60),
 	}
 
-	_, err = buyTokens(client, sw, dex, pricer)
+	book, err := ledger.Open(conf.LedgerPath)
+	if err != nil {
+		log.Fatalf("Failed to setup ledger: %s\n", err)
+	}
+	defer book.Close()
+
+	_, err = buyTokens(client, sw, dex, pricer, book)
 	if err != nil {
 		log.Fatalf("Failed to buyt tokens: %s\n", err)
 	}
 }
 
-func buyTokens(client *ethclient.Client, sw *swap.DexSwap, dex *swap.Dex, pricer *swap.PriceWatcher) (*types.Receipt, error) {
+func buyTokens(client *ethclient.Client, sw *swap.DexSwap, dex *swap.Dex, pricer *swap.PriceWatcher, book *ledger.Ledger) (*types.Receipt, error) {
 	var err error
 	ctx := context.Background()
 
@@ -134,9 +144,25 @@ func buyTokens(client *ethclient.Client, sw *swap.DexSwap, dex *swap.Dex, pricer
 		log.Printf("Failed to get buy price: %s\n", err)
 	}
 
-	totalCost := tx.Cost()
-	gasUsed := new(big.Int).SetUint64(receipt.GasUsed)
-	gasFees := new(big.Int).Mul(gasUsed, tx.GasPrice())
+	gasFees, err := eth.GetTxGasFees(client, ctx, tx, receipt)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get gas fees: %s", err)
+	}
+	err = book.Record(&ledger.Event{
+		Kind:      ledger.BuyEvent,
+		Wallet:    sw.FromWallet.Address(),
+		Token:     sw.TokenOut.Address,
+		TxHash:    tx.Hash(),
+		Block:     receipt.BlockNumber.Uint64(),
+		AmountIn:  weiSpent,
+		AmountOut: weiBought,
+		Cost:      tx.Value(),
+		GasFees:   gasFees,
+	})
+	if err != nil {
+		log.Printf("Failed to record buy in ledger: %s", err)
+	}
+	totalCost := new(big.Int).Add(tx.Value(), gasFees)
 	totalFees := new(big.Int).Sub(totalCost, weiSpent)
 	dexFees := new(big.Int).Sub(totalFees, gasFees)
 
@@ -8,73 +8,215 @@ import (
 	"math/big"
 	"path/filepath"
 	"sniper/pkg/eth"
+	"sniper/pkg/ledger"
+	"sniper/pkg/swap"
 	"sniper/pkg/triggers"
 	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/params"
 	"gopkg.in/yaml.v2"
 )
 
 type ConfigFile struct {
 	PrivateKey string `yaml:"privateKey"`
-	Network    struct {
+	Wallet     struct {
+		WalletFile `yaml:",inline"`
+		// Pool holds the wallets buys are split across along with the main one
+		Pool        []WalletFile `yaml:"pool"`
+		Consolidate bool         `yaml:"consolidate"`
+	} `yaml:"wallet"`
+	Network struct {
 		Rpc struct {
 			Url      string `yaml:"url"`
 			Login    string `yaml:"login"`
 			Password string `yaml:"password"`
 		} `yaml:"rpc"`
-		RpcLogin       string `yaml:"rpcLogin"`
-		RpcPassword    string `yaml:"rpcPassword"`
-		ChainID        int64  `yaml:"chainID"`
-		FactoryAddress string `yaml:"factoryAddress"`
-		RouterAddress  string `yaml:"routerAddress"`
-		CoinSymbol     string `yaml:"coinSymbol"`
+		ExtraRpcUrls   []string `yaml:"extraRpcUrls"`
+		RpcLogin       string   `yaml:"rpcLogin"`
+		RpcPassword    string   `yaml:"rpcPassword"`
+		ChainID        int64    `yaml:"chainID"`
+		FactoryAddress string   `yaml:"factoryAddress"`
+		RouterAddress  string   `yaml:"routerAddress"`
+		CoinSymbol     string   `yaml:"coinSymbol"`
+		DexFeeBps      int64    `yaml:"dexFeeBps"`
+		// BaseTokens are the tokens swaps may be routed through
+		BaseTokens []string `yaml:"baseTokens"`
 	} `yaml:"network"`
 	InToken struct {
 		Address   string  `yaml:"address"`
 		BuyAmount float64 `yaml:"buyAmount"`
+		Budget    float64 `yaml:"budget"`
+		// Erc20 spends the token itself instead of the native coin it wraps
+		Erc20 bool `yaml:"erc20"`
 	} `yaml:"inputToken"`
-	TargetToken struct {
-		Address       string  `yaml:"address"`
-		StartingPrice float64 `yaml:"startingPrice"`
-		MaxBuyPrice   float64 `yaml:"maxBuyPrice"`
-	} `yaml:"targetToken"`
-	BuyTrigger struct {
-		Deadline           string   `yaml:"deadline"`
-		LiquidityProviders []string `yaml:"liquidityProviders"`
-	} `yaml:"buyTrigger"`
-	SellTrigger struct {
-		Deadline string `yaml:"deadline"`
-	} `yaml:"sellTrigger"`
+	TargetToken TargetFile `yaml:"targetToken"`
+	Gas         struct {
+		Strategy   string  `yaml:"strategy"`
+		Gwei       float64 `yaml:"gwei"`
+		Multiplier float64 `yaml:"multiplier"`
+		Blocks     uint64  `yaml:"blocks"`
+		Percentile float64 `yaml:"percentile"`
+		BoostGwei  float64 `yaml:"boostGwei"`
+		CapGwei    float64 `yaml:"capGwei"`
+		SwapLimit  uint64  `yaml:"swapGasLimit"`
+
+		BumpAfterBlocks uint64 `yaml:"bumpAfterBlocks"`
+		BumpPercent     int64  `yaml:"bumpPercent"`
+		MaxBumps        int    `yaml:"maxBumps"`
+		CancelStuck     bool   `yaml:"cancelStuck"`
+	} `yaml:"gas"`
+	BuyTrigger  BuyTriggerFile  `yaml:"buyTrigger"`
+	SellTrigger SellTriggerFile `yaml:"sellTrigger"`
+	// Targets replaces targetToken, buyTrigger and sellTrigger when sniping
+	// several tokens at once
+	Targets []TargetFile `yaml:"targets"`
+	// Ledger is the file every action is recorded to
+	Ledger string `yaml:"ledger"`
+}
+
+type WalletFile struct {
+	PrivateKey    string `yaml:"privateKey"`
+	Keystore      string `yaml:"keystore"`
+	PassphraseEnv string `yaml:"passphraseEnv"`
+	SignerUrl     string `yaml:"signerUrl"`
+	Address       string `yaml:"address"`
+}
+
+type TargetFile struct {
+	Address       string          `yaml:"address"`
+	PairToken     string          `yaml:"pairToken"`
+	BuyAmount     float64         `yaml:"buyAmount"`
+	TokenAmount   float64         `yaml:"tokenAmount"`
+	SellAmount    float64         `yaml:"sellAmount"`
+	StartingPrice float64         `yaml:"startingPrice"`
+	MaxBuyPrice   float64         `yaml:"maxBuyPrice"`
+	ScaleBuy      bool            `yaml:"scaleBuyToMaxPrice"`
+	ProbeLimits   bool            `yaml:"probeLimits"`
+	BuySlippage   int64           `yaml:"buySlippageBps"`
+	SellSlippage  int64           `yaml:"sellSlippageBps"`
+	BuyWallets    int             `yaml:"buyWallets"`
+	BuyTrigger    BuyTriggerFile  `yaml:"buyTrigger"`
+	SellTrigger   SellTriggerFile `yaml:"sellTrigger"`
+}
+
+type BuyTriggerFile struct {
+	Deadline           string   `yaml:"deadline"`
+	Mode               string   `yaml:"mode"`
+	MinLiquidity       float64  `yaml:"minLiquidity"`
+	MinTokenLiquidity  float64  `yaml:"minTokenLiquidity"`
+	MaxDeployerShare   float64  `yaml:"maxDeployerShare"`
+	LiquidityProviders []string `yaml:"liquidityProviders"`
+	SimulateTrade      bool     `yaml:"simulateTrade"`
+	PreArm             bool     `yaml:"preArm"`
+	MaxBuyTax          float64  `yaml:"maxBuyTax"`
+	MaxSellTax         float64  `yaml:"maxSellTax"`
+	Bundle             struct {
+		RelayUrl  string `yaml:"relayUrl"`
+		MaxBlocks uint64 `yaml:"maxBlocks"`
+	} `yaml:"bundle"`
+	// Trading configures the tradingEnabled mode, methods are either names,
+	// signatures or hex selectors. BuyIfEnabled buys right away when trading
+	// is enabled already
+	Trading struct {
+		Owners          []string `yaml:"owners"`
+		Methods         []string `yaml:"methods"`
+		ProbeAmount     float64  `yaml:"probeAmount"`
+		ProbeIntervalMs int64    `yaml:"probeIntervalMs"`
+		BuyIfEnabled    bool     `yaml:"buyIfEnabled"`
+	} `yaml:"trading"`
+}
+
+type SellTriggerFile struct {
+	Deadline     string  `yaml:"deadline"`
+	TakeProfit   float64 `yaml:"takeProfit"`
+	StopLoss     float64 `yaml:"stopLoss"`
+	TrailingStop float64 `yaml:"trailingStop"`
 }
 
 type Config struct {
-	PrivateKey string
+	Wallet     eth.WalletSource
+	WalletPool []eth.WalletSource
+	// Consolidate sends the coins of the pool wallets back to the main one
+	// once their positions are closed
+	Consolidate bool
 
 	RpcUrl         string
+	ExtraRpcUrls   []string
 	ChainID        int64
 	FactoryAddress common.Address
 	RouterAddress  common.Address
 	EthSymbol      string
+	DexFeeBps      int64
+	BaseTokens     []common.Address
+
+	InTokenAddr    common.Address
+	InTokenIsErc20 bool
+	// Budget caps the total spent by the buys of all targets
+	Budget *big.Int
 
-	InTokenAddr      common.Address
-	InTokenBuyAmount *big.Int
+	GasStrategy  eth.GasStrategy
+	SwapGasLimit uint64
+	// BuyGasStrategy prices the buys, which alone outbid the transaction
+	// firing them
+	BuyGasStrategy eth.GasStrategy
 
-	TargetTokenAddr          common.Address
-	TargetTokenStartingPrice *big.Float
-	TargetTokenMaxBuyPrice   *big.Float
+	BumpAfterBlocks uint64
+	BumpPercent     int64
+	MaxBumps        int
+	CancelStuck     bool
 
-	BuyTrigger  triggers.BuyTrigger
-	SellTrigger triggers.SellTrigger
+	Targets []*Target
+
+	LedgerPath string
+}
+
+type Target struct {
+	Addr common.Address
+	// PairToken is the token the target is paired against, bought through
+	// when it is not the input token
+	PairToken common.Address
+	// BuyAmount is the most spent on an exact-output buy of TokenAmount,
+	// quoted when zero. SellAmount is the exact amount of input token a sell
+	// takes out, the tokens it does not need staying held
+	BuyAmount          *big.Int
+	TokenAmount        *big.Int
+	SellAmount         *big.Int
+	StartingPrice      *big.Float
+	MaxBuyPrice        *big.Float
+	ScaleBuyToMaxPrice bool
+	BuySlippageBps     int64
+	SellSlippageBps    int64
+	// BuyWallets is the number of wallets, starting from the main one, the
+	// buy is split across
+	BuyWallets int
+	// ProbeLimits scales the buy of each wallet down to the max transaction
+	// and max wallet limits of the token
+	ProbeLimits bool
+
+	SimulateTrade   bool
+	PreArm          bool
+	BundleRelayUrl  string
+	BundleMaxBlocks uint64
+	BuyTrigger      triggers.BuyTrigger
+	SellTrigger     triggers.SellTrigger
 }
 
 func parseValues(raw ConfigFile) *Config {
-	var err error
 	c := new(Config)
 
-	c.PrivateKey = raw.PrivateKey
+	mainWallet := raw.Wallet.WalletFile
+	if mainWallet.PrivateKey == "" {
+		mainWallet.PrivateKey = raw.PrivateKey
+	}
+	c.Wallet = parseWalletSource(mainWallet)
+	for _, rawWallet := range raw.Wallet.Pool {
+		c.WalletPool = append(c.WalletPool, parseWalletSource(rawWallet))
+	}
+	c.Consolidate = raw.Wallet.Consolidate
 
 	rpcAuth := ""
 	if raw.Network.Rpc.Login != "" {
@@ -83,24 +225,163 @@ func parseValues(raw ConfigFile) *Config {
 	rpcUrl := strings.Split(raw.Network.Rpc.Url, "://")
 	c.RpcUrl = fmt.Sprintf("%s://%s%s", rpcUrl[0], rpcAuth, rpcUrl[1])
 
+	c.ExtraRpcUrls = raw.Network.ExtraRpcUrls
 	c.ChainID = raw.Network.ChainID
 	c.RouterAddress = common.HexToAddress(raw.Network.RouterAddress)
 	c.FactoryAddress = common.HexToAddress(raw.Network.FactoryAddress)
 	c.EthSymbol = raw.Network.CoinSymbol
+	c.DexFeeBps = raw.Network.DexFeeBps
+	if c.DexFeeBps == 0 {
+		c.DexFeeBps = swap.DefaultFeeBps
+	}
+
+	for _, base := range raw.Network.BaseTokens {
+		c.BaseTokens = append(c.BaseTokens, common.HexToAddress(base))
+	}
 
 	c.InTokenAddr = common.HexToAddress(raw.InToken.Address)
-	c.InTokenBuyAmount, err = eth.ToWei(big.NewFloat(raw.InToken.BuyAmount), params.Ether)
+	c.InTokenIsErc20 = raw.InToken.Erc20
+	c.Budget = parseOptionalWei(raw.InToken.Budget)
+
+	c.BuyGasStrategy = parseGasStrategy(raw)
+	c.GasStrategy = c.BuyGasStrategy
+	if outbid, ok := c.BuyGasStrategy.(*eth.OutbidGas); ok {
+		c.GasStrategy = &eth.SuggestedGas{Cap: outbid.Cap}
+	}
+	c.SwapGasLimit = raw.Gas.SwapLimit
+	c.BumpAfterBlocks = raw.Gas.BumpAfterBlocks
+	if c.BumpAfterBlocks == 0 {
+		c.BumpAfterBlocks = eth.DefaultBumpAfterBlocks
+	}
+	c.BumpPercent = raw.Gas.BumpPercent
+	if c.BumpPercent < 10 {
+		c.BumpPercent = eth.DefaultBumpPercent
+	}
+	c.MaxBumps = raw.Gas.MaxBumps
+	if c.MaxBumps == 0 {
+		c.MaxBumps = eth.DefaultMaxBumps
+	}
+	c.CancelStuck = raw.Gas.CancelStuck
+
+	c.LedgerPath = raw.Ledger
+	if c.LedgerPath == "" {
+		c.LedgerPath = ledger.DefaultPath
+	}
+
+	rawTargets := raw.Targets
+	if len(rawTargets) == 0 {
+		single := raw.TargetToken
+		single.BuyTrigger = raw.BuyTrigger
+		single.SellTrigger = raw.SellTrigger
+		rawTargets = []TargetFile{single}
+	}
+	for _, rawTarget := range rawTargets {
+		if rawTarget.BuyAmount <= 0 {
+			rawTarget.BuyAmount = raw.InToken.BuyAmount
+		}
+		c.Targets = append(c.Targets, c.parseTarget(rawTarget))
+	}
+
+	return c
+}
+
+func (c *Config) parseTarget(raw TargetFile) *Target {
+	var err error
+	t := new(Target)
+
+	t.Addr = common.HexToAddress(raw.Address)
+	t.PairToken = c.InTokenAddr
+	if raw.PairToken != "" {
+		t.PairToken = common.HexToAddress(raw.PairToken)
+	}
+	t.BuyAmount, err = eth.ToWei(big.NewFloat(raw.BuyAmount), params.Ether)
 	if err != nil {
 		log.Fatalf("Failed to parse buyAmount")
 	}
+	t.TokenAmount = parseOptionalWei(raw.TokenAmount)
+	t.SellAmount = parseOptionalWei(raw.SellAmount)
+	t.StartingPrice = big.NewFloat(raw.StartingPrice)
+	t.MaxBuyPrice = parseOptionalFloat(raw.MaxBuyPrice)
+	t.ScaleBuyToMaxPrice = raw.ScaleBuy
+	t.ProbeLimits = raw.ProbeLimits
+	t.BuySlippageBps = parseSlippage(raw.BuySlippage)
+	t.SellSlippageBps = parseSlippage(raw.SellSlippage)
+	t.BuyWallets = raw.BuyWallets
+	if t.BuyWallets <= 0 {
+		t.BuyWallets = 1
+	}
+	if t.BuyWallets > 1+len(c.WalletPool) {
+		log.Fatalf("Buy of %s is split across %d wallets but only %d are configured", raw.Address, t.BuyWallets, 1+len(c.WalletPool))
+	}
 
-	c.TargetTokenAddr = common.HexToAddress(raw.TargetToken.Address)
-	c.TargetTokenStartingPrice = big.NewFloat(raw.TargetToken.StartingPrice)
-	c.TargetTokenMaxBuyPrice = big.NewFloat(raw.TargetToken.MaxBuyPrice)
-
-	c.BuyTrigger.Deadline, err = parseTimeStr("UTC", time.RFC3339, raw.BuyTrigger.Deadline)
+	t.BuyTrigger.Deadline, err = parseTimeStr("UTC", time.RFC3339, raw.BuyTrigger.Deadline)
 	if err != nil {
-		log.Printf("Failed to parse buyDeadline, will not buy based on time.")
+		log.Printf("Failed to parse buyDeadline of %s, will not buy based on time.", raw.Address)
+	}
+
+	switch triggers.TriggerMode(raw.BuyTrigger.Mode) {
+	case "", triggers.MempoolMode:
+		t.BuyTrigger.Mode = triggers.MempoolMode
+	case triggers.PairCreatedMode:
+		t.BuyTrigger.Mode = triggers.PairCreatedMode
+	case triggers.TradingEnabledMode:
+		t.BuyTrigger.Mode = triggers.TradingEnabledMode
+		t.BuyTrigger.TradingFilter = parseTradingFilter(raw.BuyTrigger)
+		t.BuyTrigger.ProbeAmount = parseOptionalWei(raw.BuyTrigger.Trading.ProbeAmount)
+		t.BuyTrigger.ProbeInterval = time.Duration(raw.BuyTrigger.Trading.ProbeIntervalMs) * time.Millisecond
+		t.BuyTrigger.BuyIfEnabled = raw.BuyTrigger.Trading.BuyIfEnabled
+	default:
+		log.Fatalf("Unknown buy trigger mode %s", raw.BuyTrigger.Mode)
+	}
+	t.BuyTrigger.BaseToken = t.PairToken
+	t.BuyTrigger.MinLiquidity = parseOptionalWei(raw.BuyTrigger.MinLiquidity)
+
+	t.SimulateTrade = raw.BuyTrigger.SimulateTrade
+	t.PreArm = raw.BuyTrigger.PreArm
+	if _, outbid := c.BuyGasStrategy.(*eth.OutbidGas); t.PreArm && outbid {
+		log.Fatalf("Buys of %s cannot be pre-signed with the outbid gas strategy", raw.Address)
+	}
+	// before liquidity is added the buy can neither be estimated nor quoted
+	if t.PreArm && c.SwapGasLimit == 0 {
+		log.Fatalf("Pre-signing the buy of %s requires swapGasLimit", raw.Address)
+	}
+	if t.PreArm && t.TokenAmount == nil && t.MaxBuyPrice == nil {
+		log.Fatalf("Pre-signing the buy of %s requires maxBuyPrice to set its minimum output", raw.Address)
+	}
+	t.BuyTrigger.MaxBuyTax = parseOptionalFloat(raw.BuyTrigger.MaxBuyTax)
+	t.BuyTrigger.MaxSellTax = parseOptionalFloat(raw.BuyTrigger.MaxSellTax)
+	// fee-on-transfer swaps check the output received after the tax, so the
+	// slippage has to cover the highest tax accepted
+	if !slippageCovers(t.BuySlippageBps, t.BuyTrigger.MaxBuyTax) || !slippageCovers(t.SellSlippageBps, t.BuyTrigger.MaxSellTax) {
+		log.Fatalf("Slippage of %s must cover maxBuyTax and maxSellTax, buys and sells of taxed tokens revert otherwise", raw.Address)
+	}
+
+	t.BundleRelayUrl = raw.BuyTrigger.Bundle.RelayUrl
+	t.BundleMaxBlocks = raw.BuyTrigger.Bundle.MaxBlocks
+	if t.BundleMaxBlocks == 0 {
+		t.BundleMaxBlocks = eth.DefaultBundleMaxBlocks
+	}
+	if t.BundleRelayUrl != "" && c.SwapGasLimit == 0 {
+		log.Fatalf("Bundle submission requires swapGasLimit, the buy cannot be estimated before liquidity is added")
+	}
+	// the price checks read the reserves of the pair with the input token
+	if t.PairToken != c.InTokenAddr && (t.MaxBuyPrice != nil || t.SimulateTrade || t.BundleRelayUrl != "") {
+		log.Fatalf("Buy of %s through %s cannot check a max buy price, be simulated or bundled", raw.Address, raw.PairToken)
+	}
+	// limits are probed on the current state, which lacks pending liquidity
+	if t.ProbeLimits && t.BuyTrigger.Mode == triggers.MempoolMode {
+		log.Fatalf("Limits of %s can only be probed with the pairCreated or tradingEnabled buy trigger", raw.Address)
+	}
+	// the simulated sell swaps back for coins
+	if t.SimulateTrade && c.InTokenIsErc20 {
+		log.Fatalf("Buy of %s cannot be simulated with an erc20 input token", raw.Address)
+	}
+	// without a max input the buy is quoted once liquidity is added
+	if t.TokenAmount != nil && t.BuyAmount.Sign() == 0 && (t.PreArm || t.SimulateTrade || t.BundleRelayUrl != "") {
+		log.Fatalf("Buy of %s needs a buyAmount to be pre-signed, simulated or bundled", raw.Address)
+	}
+	if t.BuyWallets > 1 && (t.PreArm || t.BundleRelayUrl != "") {
+		log.Fatalf("Buy of %s cannot be split across wallets when it is pre-signed or bundled", raw.Address)
 	}
 
 	var providers []common.Address
@@ -108,19 +389,52 @@ func parseValues(raw ConfigFile) *Config {
 		providers = append(providers, common.HexToAddress(str))
 	}
 
-	c.BuyTrigger.MempoolFilter = triggers.TxFilter{
+	t.BuyTrigger.MempoolFilter = triggers.TxFilter{
 		From:              providers,
 		To:                []common.Address{c.RouterAddress},
 		Methods:           []string{"addLiquidityETH", "addLiquidity"},
 		TargetTokenFields: []string{"token", "tokenA", "tokenB"},
+		MinLiquidity:      t.BuyTrigger.MinLiquidity,
+		MinTokenLiquidity: parseOptionalWei(raw.BuyTrigger.MinTokenLiquidity),
+		MaxDeployerShare:  parseOptionalFloat(raw.BuyTrigger.MaxDeployerShare),
 	}
 
-	c.SellTrigger.Deadline, err = parseTimeStr("UTC", time.RFC3339, raw.SellTrigger.Deadline)
+	t.SellTrigger.Deadline, err = parseTimeStr("UTC", time.RFC3339, raw.SellTrigger.Deadline)
 	if err != nil {
-		log.Printf("Failed to parse sellDeadline, will not sell based on time.")
+		log.Printf("Failed to parse sellDeadline of %s, will not sell based on time.", raw.Address)
 	}
+	t.SellTrigger.TakeProfit = parseOptionalFloat(raw.SellTrigger.TakeProfit)
+	t.SellTrigger.StopLoss = parseOptionalFloat(raw.SellTrigger.StopLoss)
+	t.SellTrigger.TrailingStop = parseOptionalFloat(raw.SellTrigger.TrailingStop)
 
-	return c
+	return t
+}
+
+func parseTradingFilter(raw BuyTriggerFile) triggers.TradingFilter {
+	var filter triggers.TradingFilter
+	for _, owner := range raw.Trading.Owners {
+		filter.From = append(filter.From, common.HexToAddress(owner))
+	}
+
+	methods := raw.Trading.Methods
+	if len(methods) == 0 {
+		methods = triggers.DefaultTradingMethods
+	}
+	for _, method := range methods {
+		if strings.HasPrefix(method, "0x") {
+			selector, err := hexutil.Decode(method)
+			if err != nil || len(selector) != 4 {
+				log.Fatalf("Invalid trading method selector %s", method)
+			}
+			filter.Selectors = append(filter.Selectors, selector)
+			continue
+		}
+		if !strings.Contains(method, "(") {
+			method += "()"
+		}
+		filter.Selectors = append(filter.Selectors, crypto.Keccak256([]byte(method))[:4])
+	}
+	return filter
 }
 
 func FromYaml(path string) (c *Config, err error) {
@@ -152,3 +466,115 @@ func parseTimeStr(location string, layout string, str string) (*time.Time, error
 	}
 	return &t, nil
 }
+
+// parseWalletSource keeps the raw private key optional, the wallet being
+// opened from a keystore or an external signer instead.
+func parseWalletSource(raw WalletFile) eth.WalletSource {
+	src := eth.WalletSource{
+		PrivateKey: raw.PrivateKey,
+		Keystore:   raw.Keystore,
+		SignerUrl:  raw.SignerUrl,
+	}
+	if raw.Address != "" {
+		if !common.IsHexAddress(raw.Address) {
+			log.Fatalf("Invalid wallet address %s", raw.Address)
+		}
+		src.Address = common.HexToAddress(raw.Address)
+	}
+
+	sources := 0
+	for _, set := range []bool{src.PrivateKey != "", src.Keystore != "", src.SignerUrl != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		log.Fatalf("Exactly one of privateKey, wallet.keystore and wallet.signerUrl is required")
+	}
+
+	passphraseEnv := raw.PassphraseEnv
+	if passphraseEnv == "" {
+		passphraseEnv = eth.DefaultPassphraseEnv
+	}
+	src.Passphrase = eth.PassphraseFrom(passphraseEnv)
+	return src
+}
+
+func parseGasStrategy(raw ConfigFile) eth.GasStrategy {
+	gasCap := parseGwei(raw.Gas.CapGwei)
+	if gasCap == nil {
+		log.Printf("No gas price cap set, gas strategy is unbounded.")
+	}
+
+	switch raw.Gas.Strategy {
+	case "fixed":
+		price := parseGwei(raw.Gas.Gwei)
+		if price == nil {
+			log.Fatalf("Fixed gas strategy requires gwei")
+		}
+		return &eth.FixedGas{Price: price, Cap: gasCap}
+	case "", "suggested":
+		return &eth.SuggestedGas{Multiplier: parseOptionalFloat(raw.Gas.Multiplier), Cap: gasCap}
+	case "percentile":
+		blocks := raw.Gas.Blocks
+		if blocks == 0 {
+			blocks = 10
+		}
+		percentile := raw.Gas.Percentile
+		if percentile <= 0 || percentile > 100 {
+			percentile = 50
+		}
+		return &eth.PercentileGas{Blocks: blocks, Percentile: percentile, Cap: gasCap}
+	case "outbid":
+		return &eth.OutbidGas{Boost: parseGwei(raw.Gas.BoostGwei), Cap: gasCap}
+	}
+
+	log.Fatalf("Unknown gas strategy %s", raw.Gas.Strategy)
+	return nil
+}
+
+func parseGwei(gwei float64) *big.Int {
+	if gwei <= 0 {
+		return nil
+	}
+	wei, err := eth.ToWei(big.NewFloat(gwei), params.GWei)
+	if err != nil {
+		log.Fatalf("Failed to parse gwei value %f", gwei)
+	}
+	return wei
+}
+
+func parseOptionalWei(val float64) *big.Int {
+	if val <= 0 {
+		return nil
+	}
+	wei, err := eth.ToWei(big.NewFloat(val), params.Ether)
+	if err != nil {
+		log.Fatalf("Failed to parse value %f", val)
+	}
+	return wei
+}
+
+func parseSlippage(bps int64) int64 {
+	if bps <= 0 {
+		return swap.DefaultSlippageBps
+	}
+	if bps >= 10000 {
+		log.Fatalf("Slippage must be below 10000 bps, got %d", bps)
+	}
+	return bps
+}
+
+func slippageCovers(bps int64, tax *big.Float) bool {
+	if tax == nil {
+		return true
+	}
+	return big.NewFloat(float64(bps)/10000).Cmp(tax) >= 0
+}
+
+func parseOptionalFloat(val float64) *big.Float {
+	if val <= 0 {
+		return nil
+	}
+	return big.NewFloat(val)
+}
@@ -0,0 +1,125 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	ErrBundleTargetMined = errors.New("first bundle transaction was mined without the bundle")
+	ErrBundleNotIncluded = errors.New("bundle was not included")
+)
+
+// Bundle is the argument of eth_sendBundle: transactions that must be
+// included in order in the given block, or not at all.
+type Bundle struct {
+	Txs         []hexutil.Bytes `json:"txs"`
+	BlockNumber hexutil.Uint64  `json:"blockNumber"`
+}
+
+// BundleRelay submits bundles to a block builder or relay speaking
+// eth_sendBundle.
+type BundleRelay struct {
+	Url       string
+	MaxBlocks uint64
+	client    *rpc.Client
+}
+
+const DefaultBundleMaxBlocks = 3
+
+func NewBundleRelay(ctx context.Context, url string) (*BundleRelay, error) {
+	client, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot connect to bundle relay %s: %s", url, err)
+	}
+
+	r := &BundleRelay{
+		Url:       url,
+		MaxBlocks: DefaultBundleMaxBlocks,
+		client:    client,
+	}
+	return r, nil
+}
+
+func (r *BundleRelay) SendBundle(ctx context.Context, txs []*types.Transaction, block uint64) error {
+	bundle := Bundle{BlockNumber: hexutil.Uint64(block)}
+	for _, tx := range txs {
+		raw, err := tx.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("Failed to encode transaction %s: %s", tx.Hash().Hex(), err)
+		}
+		bundle.Txs = append(bundle.Txs, raw)
+	}
+
+	var res json.RawMessage
+	err := r.client.CallContext(ctx, &res, "eth_sendBundle", bundle)
+	if err != nil {
+		return fmt.Errorf("Failed to send bundle for block %d: %s", block, err)
+	}
+	log.Printf("Bundle sent for block %d: %s", block, string(res))
+	return nil
+}
+
+// SendUntilIncluded submits the bundle for the next block, and again for each
+// following one, until its last transaction is mined, its first transaction is
+// mined without it, or MaxBlocks blocks went by.
+func (r *BundleRelay) SendUntilIncluded(ctx context.Context, client *ethclient.Client, txs []*types.Transaction) (*types.Receipt, error) {
+	first, last := txs[0], txs[len(txs)-1]
+
+	for i := uint64(0); i < r.MaxBlocks; i++ {
+		head, err := client.BlockNumber(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get block number: %s", err)
+		}
+		target := head + 1
+
+		err = r.SendBundle(ctx, txs, target)
+		if err != nil {
+			return nil, err
+		}
+
+		err = waitForBlock(ctx, client, target)
+		if err != nil {
+			return nil, err
+		}
+
+		receipt, err := client.TransactionReceipt(ctx, last.Hash())
+		if err == nil {
+			log.Printf("Bundle included in block %s", receipt.BlockNumber)
+			return receipt, nil
+		}
+		if _, err := client.TransactionReceipt(ctx, first.Hash()); err == nil {
+			return nil, ErrBundleTargetMined
+		}
+		log.Printf("Bundle not included in block %d", target)
+	}
+
+	return nil, ErrBundleNotIncluded
+}
+
+func waitForBlock(ctx context.Context, client *ethclient.Client, block uint64) error {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		head, err := client.BlockNumber(ctx)
+		if err == nil && head >= block {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRelay struct {
+	bundles []Bundle
+}
+
+func (r *testRelay) SendBundle(bundle Bundle) (map[string]string, error) {
+	r.bundles = append(r.bundles, bundle)
+	return map[string]string{"bundleHash": common.Hash{}.Hex()}, nil
+}
+
+func TestSendBundle(t *testing.T) {
+	relay := &testRelay{}
+	server := rpc.NewServer()
+	err := server.RegisterName("eth", relay)
+	assert.Nil(t, err)
+	defer server.Stop()
+
+	r := &BundleRelay{MaxBlocks: DefaultBundleMaxBlocks, client: rpc.DialInProc(server)}
+
+	target := types.NewTransaction(7, common.HexToAddress("0x1"), big.NewInt(0), 21000, big.NewInt(1), nil)
+	buy := types.NewTransaction(0, common.HexToAddress("0x2"), big.NewInt(1), 21000, big.NewInt(1), nil)
+	err = r.SendBundle(context.Background(), []*types.Transaction{target, buy}, 100)
+	assert.Nil(t, err)
+
+	if assert.Len(t, relay.bundles, 1) {
+		bundle := relay.bundles[0]
+		assert.Equal(t, uint64(100), uint64(bundle.BlockNumber))
+		if assert.Len(t, bundle.Txs, 2) {
+			for i, tx := range []*types.Transaction{target, buy} {
+				decoded := new(types.Transaction)
+				assert.Nil(t, decoded.UnmarshalBinary(bundle.Txs[i]))
+				assert.Equal(t, tx.Hash(), decoded.Hash())
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// BuildSweepTx builds the transfer of the whole coin balance of the wallet to
+// the address, keeping what the transfer can cost in gas.
+func (w *Wallet) BuildSweepTx(client *ethclient.Client, ctx context.Context, to common.Address, strategy GasStrategy) (*types.Transaction, error) {
+	balance, err := client.BalanceAt(ctx, w.Address(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get balance: %s", err)
+	}
+
+	fees, err := GetGasFees(client, ctx, strategy)
+	if err != nil {
+		return nil, err
+	}
+	price := fees.GasPrice
+	if fees.IsDynamic() {
+		price = fees.GasFeeCap
+	}
+	value := new(big.Int).Mul(price, big.NewInt(int64(params.TxGas)))
+	value.Sub(balance, value)
+	if value.Sign() <= 0 {
+		return nil, fmt.Errorf("Balance of %s does not cover the transfer gas", w.Address().Hex())
+	}
+
+	nonce, err := w.Nonces.Next(client, ctx)
+	if err != nil {
+		return nil, err
+	}
+	var data types.TxData
+	if fees.IsDynamic() {
+		data = &types.DynamicFeeTx{
+			ChainID:   w.chainID,
+			Nonce:     nonce,
+			GasTipCap: fees.GasTipCap,
+			GasFeeCap: fees.GasFeeCap,
+			Gas:       params.TxGas,
+			To:        &to,
+			Value:     value,
+		}
+	} else {
+		data = &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: fees.GasPrice,
+			Gas:      params.TxGas,
+			To:       &to,
+			Value:    value,
+		}
+	}
+
+	opts, err := w.GetSignerOpts()
+	if err != nil {
+		w.Nonces.Release(nonce)
+		return nil, err
+	}
+	tx, err := opts.Signer(opts.From, types.NewTx(data))
+	if err != nil {
+		w.Nonces.Release(nonce)
+		return nil, fmt.Errorf("Failed to sign transfer: %s", err)
+	}
+	return tx, nil
+}
+
+// BuildTokenTransferTx builds the transfer of amount of the token to the
+// address.
+func (w *Wallet) BuildTokenTransferTx(client *ethclient.Client, ctx context.Context, token *Token, to common.Address, amount *big.Int, strategy GasStrategy) (*types.Transaction, error) {
+	fees, err := GetGasFees(client, ctx, strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := w.GetSignerOpts()
+	if err != nil {
+		return nil, err
+	}
+	if fees.IsDynamic() {
+		opts.GasFeeCap = fees.GasFeeCap
+		opts.GasTipCap = fees.GasTipCap
+	} else {
+		opts.GasPrice = fees.GasPrice
+	}
+	opts.Context = ctx
+	opts.NoSend = true
+
+	nonce, err := w.Nonces.Next(client, ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts.Nonce = new(big.Int).SetUint64(nonce)
+
+	tx, err := token.Transfer(opts, to, amount)
+	if err != nil {
+		w.Nonces.Release(nonce)
+		return nil, fmt.Errorf("Failed to build %s transfer: %s", token.Symbol, err)
+	}
+	return tx, nil
+}
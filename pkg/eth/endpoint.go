@@ -0,0 +1,233 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// TxSender broadcasts signed transactions, either through a single client or
+// through every endpoint of a Network.
+type TxSender interface {
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+}
+
+const DefaultBroadcastTimeout = 10 * time.Second
+
+// Endpoint is one of the RPC nodes of a Network, with the latency of its last
+// pings and the outcome of the requests sent through it.
+type Endpoint struct {
+	Url    string
+	client *ethclient.Client
+
+	mu      sync.Mutex
+	healthy bool
+	latency time.Duration
+	stats   EndpointStats
+}
+
+type EndpointStats struct {
+	Url     string
+	Healthy bool
+	// Latency is a moving average of the ping round trips
+	Latency   time.Duration
+	Requests  uint64
+	Errors    uint64
+	FirstAcks uint64
+}
+
+func newEndpoint(url string, client *ethclient.Client) *Endpoint {
+	return &Endpoint{
+		Url:     url,
+		client:  client,
+		healthy: true,
+	}
+}
+
+func (e *Endpoint) Client() *ethclient.Client {
+	return e.client
+}
+
+func (e *Endpoint) Stats() EndpointStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := e.stats
+	stats.Url = e.Url
+	stats.Healthy = e.healthy
+	stats.Latency = e.latency
+	return stats
+}
+
+func (e *Endpoint) isHealthy() (bool, time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.healthy, e.latency
+}
+
+func (e *Endpoint) record(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.stats.Requests++
+	if err != nil {
+		e.stats.Errors++
+	}
+}
+
+func (e *Endpoint) ping(ctx context.Context) error {
+	start := time.Now()
+	_, err := e.client.BlockNumber(ctx)
+	elapsed := time.Since(start)
+	e.record(err)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.healthy = err == nil
+	if err != nil {
+		return err
+	}
+	if e.latency == 0 {
+		e.latency = elapsed
+	} else {
+		e.latency = (3*e.latency + elapsed) / 4
+	}
+	return nil
+}
+
+// Endpoints returns the primary endpoint followed by the extra ones.
+func (n *Network) Endpoints() []*Endpoint {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return append([]*Endpoint{}, n.endpoints...)
+}
+
+func (n *Network) Stats() []EndpointStats {
+	var stats []EndpointStats
+	for _, e := range n.Endpoints() {
+		stats = append(stats, e.Stats())
+	}
+	return stats
+}
+
+// Client returns the client of the healthy endpoint with the lowest latency,
+// or the primary one when none is healthy.
+func (n *Network) Client() *ethclient.Client {
+	endpoints := n.Endpoints()
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	best := endpoints[0]
+	var bestLatency time.Duration
+	for _, e := range endpoints {
+		healthy, latency := e.isHealthy()
+		if !healthy || latency == 0 {
+			continue
+		}
+		if bestLatency == 0 || latency < bestLatency {
+			best, bestLatency = e, latency
+		}
+	}
+	return best.client
+}
+
+// SendTransaction broadcasts the transaction to every endpoint in parallel
+// and returns as soon as one of them accepts it. The others keep sending in
+// the background.
+func (n *Network) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	endpoints := n.Endpoints()
+	type result struct {
+		endpoint *Endpoint
+		err      error
+	}
+	results := make(chan result, len(endpoints))
+
+	sendCtx, cancel := context.WithTimeout(context.Background(), DefaultBroadcastTimeout)
+	var wg sync.WaitGroup
+	for _, e := range endpoints {
+		wg.Add(1)
+		go func(e *Endpoint) {
+			defer wg.Done()
+			err := e.client.SendTransaction(sendCtx, tx)
+			// another endpoint got the transaction to this node first
+			if err != nil && strings.Contains(err.Error(), "already known") {
+				err = nil
+			}
+			e.record(err)
+			results <- result{e, err}
+		}(e)
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+
+	var errs []string
+	for range endpoints {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case r := <-results:
+			if r.err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", r.endpoint.Url, r.err))
+				continue
+			}
+			r.endpoint.mu.Lock()
+			r.endpoint.stats.FirstAcks++
+			r.endpoint.mu.Unlock()
+			if len(endpoints) > 1 {
+				log.Printf("Transaction %s first acknowledged by %s", tx.Hash().Hex(), r.endpoint.Url)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("No endpoint accepted the transaction: %s", strings.Join(errs, ", "))
+}
+
+// ClientSource picks the client reads go to, a Network choosing the fastest
+// healthy endpoint for each of them.
+type ClientSource interface {
+	Client() *ethclient.Client
+}
+
+type fixedClient struct {
+	client *ethclient.Client
+}
+
+func (c fixedClient) Client() *ethclient.Client {
+	return c.client
+}
+
+// ReadBackend sends the contract calls of bindings to the client picked by
+// Reads, leaving transactions and log subscriptions to the backend it wraps.
+type ReadBackend struct {
+	bind.ContractBackend
+	Reads ClientSource
+}
+
+func (b *ReadBackend) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return b.Reads.Client().CodeAt(ctx, contract, blockNumber)
+}
+
+func (b *ReadBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return b.Reads.Client().CallContract(ctx, call, blockNumber)
+}
+
+func (b *ReadBackend) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	return b.Reads.Client().PendingCodeAt(ctx, account)
+}
+
+func (b *ReadBackend) PendingCallContract(ctx context.Context, call ethereum.CallMsg) ([]byte, error) {
+	return b.Reads.Client().PendingCallContract(ctx, call)
+}
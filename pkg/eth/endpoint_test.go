@@ -0,0 +1,91 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+type testNode struct {
+	url string
+	err error
+}
+
+// Call answers every call with the url of the node.
+func (n *testNode) Call(args map[string]interface{}, block string) hexutil.Bytes {
+	return hexutil.Bytes(n.url)
+}
+
+func (n *testNode) SendRawTransaction(raw hexutil.Bytes) (common.Hash, error) {
+	if n.err != nil {
+		return common.Hash{}, n.err
+	}
+	tx := new(types.Transaction)
+	err := tx.UnmarshalBinary(raw)
+	return tx.Hash(), err
+}
+
+func newTestEndpoint(t *testing.T, url string, err error) *Endpoint {
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("eth", &testNode{url: url, err: err}))
+	t.Cleanup(server.Stop)
+	return newEndpoint(url, ethclient.NewClient(rpc.DialInProc(server)))
+}
+
+func TestNetworkSendTransaction(t *testing.T) {
+	failing := newTestEndpoint(t, "failing", errors.New("nonce too low"))
+	known := newTestEndpoint(t, "known", errors.New("already known"))
+	n := &Network{endpoints: []*Endpoint{failing, known}}
+
+	tx := types.NewTransaction(0, common.HexToAddress("0x1"), big.NewInt(0), 21000, big.NewInt(1), nil)
+	err := n.SendTransaction(context.Background(), tx)
+	assert.Nil(t, err, "a transaction already known to a node should count as accepted")
+	assert.Equal(t, uint64(1), known.Stats().FirstAcks)
+
+	n = &Network{endpoints: []*Endpoint{failing}}
+	err = n.SendTransaction(context.Background(), tx)
+	assert.NotNil(t, err)
+	assert.Eventually(t, func() bool { return failing.Stats().Errors == 2 }, time.Second, time.Millisecond)
+}
+
+func TestNetworkClient(t *testing.T) {
+	primary := newTestEndpoint(t, "primary", nil)
+	slow := newTestEndpoint(t, "slow", nil)
+	fast := newTestEndpoint(t, "fast", nil)
+	down := newTestEndpoint(t, "down", nil)
+	n := &Network{endpoints: []*Endpoint{primary, slow, fast, down}}
+
+	assert.Equal(t, primary.Client(), n.Client(), "should read from the primary endpoint before any ping")
+
+	slow.latency = 50 * time.Millisecond
+	fast.latency = 10 * time.Millisecond
+	down.latency = time.Millisecond
+	down.healthy = false
+	assert.Equal(t, fast.Client(), n.Client())
+}
+
+func TestReadBackend(t *testing.T) {
+	primary := newTestEndpoint(t, "primary", nil)
+	fast := newTestEndpoint(t, "fast", nil)
+	n := &Network{endpoints: []*Endpoint{primary, fast}}
+	backend := &ReadBackend{ContractBackend: primary.Client(), Reads: n}
+
+	out, err := backend.CallContract(context.Background(), ethereum.CallMsg{}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "primary", string(out))
+
+	fast.latency = time.Millisecond
+	out, err = backend.CallContract(context.Background(), ethereum.CallMsg{}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "fast", string(out), "calls should follow the fastest endpoint")
+}
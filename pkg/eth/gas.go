@@ -0,0 +1,268 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// GasStrategy prices transactions. Every strategy is bounded by its Cap, when
+// one is set.
+type GasStrategy interface {
+	GasPrice(client *ethclient.Client, ctx context.Context) (*big.Int, error)
+	GasCap() *big.Int
+}
+
+// GasFees holds the pricing of a transaction. Legacy transactions only set
+// GasPrice, dynamic fee transactions set GasFeeCap and GasTipCap.
+type GasFees struct {
+	GasPrice  *big.Int
+	GasFeeCap *big.Int
+	GasTipCap *big.Int
+}
+
+func (f *GasFees) IsDynamic() bool {
+	return f.GasFeeCap != nil
+}
+
+// DynamicGasStrategy is a GasStrategy pricing dynamic fee transactions
+// itself, rather than having GetGasFees derive their fees from its gas price.
+type DynamicGasStrategy interface {
+	GasFees(client *ethclient.Client, ctx context.Context, baseFee *big.Int) (*GasFees, error)
+}
+
+// GetGasFees prices a transaction with the given strategy. When the chain has
+// a base fee, the tip suggested by the node is raised to whatever the strategy
+// is willing to pay above the base fee, and the fee cap leaves room for the
+// base fee to double without exceeding the strategy cap.
+func GetGasFees(client *ethclient.Client, ctx context.Context, strategy GasStrategy) (*GasFees, error) {
+	if strategy == nil {
+		strategy = &SuggestedGas{}
+	}
+
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get latest block header: %s", err)
+	}
+	if head.BaseFee == nil {
+		price, err := strategy.GasPrice(client, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &GasFees{GasPrice: price}, nil
+	}
+	if dynamic, ok := strategy.(DynamicGasStrategy); ok {
+		return dynamic.GasFees(client, ctx, head.BaseFee)
+	}
+
+	price, err := strategy.GasPrice(client, ctx)
+	if err != nil {
+		return nil, err
+	}
+	tip, err := client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get suggested gas tip: %s", err)
+	}
+	if extra := new(big.Int).Sub(price, head.BaseFee); extra.Cmp(tip) > 0 {
+		tip = extra
+	}
+	return dynamicFees(head.BaseFee, tip, strategy.GasCap()), nil
+}
+
+// dynamicFees pays tip over a fee cap leaving room for the base fee to
+// double, bounded by limit.
+func dynamicFees(baseFee, tip, limit *big.Int) *GasFees {
+	feeCap := new(big.Int).Mul(baseFee, big.NewInt(2))
+	feeCap.Add(feeCap, tip)
+	feeCap = capGasPrice(feeCap, limit)
+	if tip.Cmp(feeCap) > 0 {
+		tip = new(big.Int).Set(feeCap)
+	}
+	return &GasFees{GasFeeCap: feeCap, GasTipCap: tip}
+}
+
+// FixedGas always pays the same gas price.
+type FixedGas struct {
+	Price *big.Int
+	Cap   *big.Int
+}
+
+func (g *FixedGas) GasCap() *big.Int {
+	return g.Cap
+}
+
+func (g *FixedGas) GasPrice(client *ethclient.Client, ctx context.Context) (*big.Int, error) {
+	return capGasPrice(g.Price, g.Cap), nil
+}
+
+// GasFees makes the fixed price the fee cap, tipping whatever the base fee
+// leaves of it, so that the price paid is the fixed one.
+func (g *FixedGas) GasFees(client *ethclient.Client, ctx context.Context, baseFee *big.Int) (*GasFees, error) {
+	feeCap := capGasPrice(g.Price, g.Cap)
+	tip := new(big.Int).Sub(feeCap, baseFee)
+	if tip.Sign() < 0 {
+		log.Printf("Base fee %f gwei is above the fixed gas price, the transaction waits for it to drop", FromWei(baseFee, params.GWei))
+		tip.SetInt64(0)
+	}
+	return &GasFees{GasFeeCap: new(big.Int).Set(feeCap), GasTipCap: tip}, nil
+}
+
+// SuggestedGas multiplies the gas price suggested by the node.
+type SuggestedGas struct {
+	Multiplier *big.Float
+	Cap        *big.Int
+}
+
+func (g *SuggestedGas) GasCap() *big.Int {
+	return g.Cap
+}
+
+func (g *SuggestedGas) GasPrice(client *ethclient.Client, ctx context.Context) (*big.Int, error) {
+	suggested, err := client.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get suggested gas price: %s", err)
+	}
+
+	price := suggested
+	if g.Multiplier != nil {
+		price, _ = new(big.Float).Mul(new(big.Float).SetInt(suggested), g.Multiplier).Int(nil)
+	}
+	return capGasPrice(price, g.Cap), nil
+}
+
+// PercentileGas pays the next base fee plus the median, over the last Blocks
+// blocks, of the given percentile of the tips paid in each block, read
+// through eth_feeHistory on Rpc. On chains without base fee the tips are the
+// whole gas prices.
+type PercentileGas struct {
+	Blocks     uint64
+	Percentile float64
+	Cap        *big.Int
+	Rpc        *rpc.Client
+}
+
+type feeHistory struct {
+	Reward       [][]*hexutil.Big `json:"reward"`
+	BaseFee      []*hexutil.Big   `json:"baseFeePerGas"`
+	GasUsedRatio []float64        `json:"gasUsedRatio"`
+}
+
+func (g *PercentileGas) GasCap() *big.Int {
+	return g.Cap
+}
+
+func (g *PercentileGas) GasPrice(client *ethclient.Client, ctx context.Context) (*big.Int, error) {
+	tip, baseFee, err := g.tip(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return capGasPrice(new(big.Int).Add(tip, baseFee), g.Cap), nil
+}
+
+func (g *PercentileGas) GasFees(client *ethclient.Client, ctx context.Context, baseFee *big.Int) (*GasFees, error) {
+	tip, _, err := g.tip(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return dynamicFees(baseFee, tip, g.Cap), nil
+}
+
+// tip returns the percentile tip along with the base fee of the next block,
+// zero on chains without base fee.
+func (g *PercentileGas) tip(ctx context.Context) (*big.Int, *big.Int, error) {
+	if g.Rpc == nil {
+		return nil, nil, errors.New("percentile gas strategy has no RPC client")
+	}
+
+	var history feeHistory
+	err := g.Rpc.CallContext(ctx, &history, "eth_feeHistory", hexutil.Uint64(g.Blocks), "latest", []float64{g.Percentile})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to get fee history: %s", err)
+	}
+
+	var tips []*big.Int
+	for i, reward := range history.Reward {
+		// empty blocks report a tip of zero
+		if len(reward) == 0 || (i < len(history.GasUsedRatio) && history.GasUsedRatio[i] == 0) {
+			continue
+		}
+		tips = append(tips, reward[0].ToInt())
+	}
+	if len(tips) == 0 {
+		return nil, nil, errors.New("no transactions found in recent blocks")
+	}
+	sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+
+	baseFee := new(big.Int)
+	if n := len(history.BaseFee); n > 0 {
+		baseFee = history.BaseFee[n-1].ToInt()
+	}
+	return new(big.Int).Set(tips[len(tips)/2]), baseFee, nil
+}
+
+// OutbidGas copies the gas price of a target transaction, usually the
+// liquidity add that fired the buy trigger, plus Boost. Without a target it
+// falls back to the price suggested by the node.
+type OutbidGas struct {
+	Target *types.Transaction
+	Boost  *big.Int
+	Cap    *big.Int
+}
+
+func (g *OutbidGas) GasCap() *big.Int {
+	return g.Cap
+}
+
+func (g *OutbidGas) GasPrice(client *ethclient.Client, ctx context.Context) (*big.Int, error) {
+	if g.Target == nil {
+		suggested, err := client.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get suggested gas price: %s", err)
+		}
+		return capGasPrice(suggested, g.Cap), nil
+	}
+
+	price := new(big.Int).Set(g.Target.GasPrice())
+	if g.Boost != nil {
+		price.Add(price, g.Boost)
+	}
+	return capGasPrice(price, g.Cap), nil
+}
+
+// GasFees outbids the tip the target actually pays, which for a dynamic fee
+// target is not its gas price but at most its tip cap.
+func (g *OutbidGas) GasFees(client *ethclient.Client, ctx context.Context, baseFee *big.Int) (*GasFees, error) {
+	if g.Target == nil {
+		tip, err := client.SuggestGasTipCap(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get suggested gas tip: %s", err)
+		}
+		return dynamicFees(baseFee, tip, g.Cap), nil
+	}
+
+	tip := g.Target.EffectiveGasTipValue(baseFee)
+	if tip.Sign() < 0 {
+		tip.SetInt64(0)
+	}
+	if g.Boost != nil {
+		tip.Add(tip, g.Boost)
+	}
+	return dynamicFees(baseFee, tip, g.Cap), nil
+}
+
+func capGasPrice(price, limit *big.Int) *big.Int {
+	if limit != nil && price.Cmp(limit) > 0 {
+		log.Printf("Gas price %f gwei is above cap, using %f gwei", FromWei(price, params.GWei), FromWei(limit, params.GWei))
+		return new(big.Int).Set(limit)
+	}
+	return price
+}
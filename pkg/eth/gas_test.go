@@ -0,0 +1,65 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+type feeHistoryNode struct {
+	history feeHistory
+}
+
+func (n *feeHistoryNode) FeeHistory(blocks hexutil.Uint64, last string, percentiles []float64) *feeHistory {
+	return &n.history
+}
+
+func TestPercentileGas(t *testing.T) {
+	node := &feeHistoryNode{history: feeHistory{
+		Reward:       [][]*hexutil.Big{{(*hexutil.Big)(big.NewInt(3))}, {(*hexutil.Big)(big.NewInt(0))}, {(*hexutil.Big)(big.NewInt(1))}, {(*hexutil.Big)(big.NewInt(2))}},
+		BaseFee:      []*hexutil.Big{(*hexutil.Big)(big.NewInt(90)), (*hexutil.Big)(big.NewInt(95)), (*hexutil.Big)(big.NewInt(99)), (*hexutil.Big)(big.NewInt(100)), (*hexutil.Big)(big.NewInt(110))},
+		GasUsedRatio: []float64{0.5, 0, 0.7, 0.4},
+	}}
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("eth", node))
+	defer server.Stop()
+
+	g := &PercentileGas{Blocks: 4, Percentile: 50, Rpc: rpc.DialInProc(server)}
+	price, err := g.GasPrice(nil, context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "112", price.String(), "empty blocks should be skipped and the next base fee added")
+
+	fees, err := g.GasFees(nil, context.Background(), big.NewInt(110))
+	assert.Nil(t, err)
+	assert.Equal(t, "2", fees.GasTipCap.String())
+	assert.Equal(t, "222", fees.GasFeeCap.String())
+}
+
+func TestFixedGasFees(t *testing.T) {
+	g := &FixedGas{Price: big.NewInt(120)}
+
+	fees, err := g.GasFees(nil, context.Background(), big.NewInt(100))
+	assert.Nil(t, err)
+	assert.Equal(t, "120", fees.GasFeeCap.String(), "the fixed price should be the most paid")
+	assert.Equal(t, "20", fees.GasTipCap.String())
+}
+
+func TestOutbidGasFees(t *testing.T) {
+	target := types.NewTx(&types.DynamicFeeTx{
+		To:        &common.Address{},
+		GasTipCap: big.NewInt(5),
+		GasFeeCap: big.NewInt(1000),
+	})
+	g := &OutbidGas{Target: target, Boost: big.NewInt(1), Cap: big.NewInt(150)}
+
+	fees, err := g.GasFees(nil, context.Background(), big.NewInt(100))
+	assert.Nil(t, err)
+	assert.Equal(t, "6", fees.GasTipCap.String(), "the tip should be outbid, not the fee cap")
+	assert.Equal(t, "150", fees.GasFeeCap.String())
+}
@@ -3,25 +3,209 @@ package eth
 import (
 	"context"
 	"fmt"
+	"log"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
+type ConnState string
+
+const (
+	Connected    ConnState = "connected"
+	Disconnected ConnState = "disconnected"
+)
+
+const (
+	DefaultHealthInterval = 5 * time.Second
+	DefaultMinBackoff     = 500 * time.Millisecond
+	DefaultMaxBackoff     = 30 * time.Second
+)
+
+// Network keeps track of the health of the node connection. The RPC client
+// redials by itself on the next call after the connection drops, so Network
+// pings the node with backoff until it answers again and renews the
+// subscriptions made through Subscribe, which do not survive the drop.
+//
+// Subscriptions go through RpcUrl, while transactions are broadcast to it and
+// to every extra endpoint of ExtraUrls.
 type Network struct {
-	RpcUrl    string
-	connected bool
+	RpcUrl         string
+	ExtraUrls      []string
+	HealthInterval time.Duration
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+
+	mu        sync.RWMutex
+	rpc       *rpc.Client
+	endpoints []*Endpoint
+	state     ConnState
+	listeners []chan ConnState
 }
 
 func (n *Network) IsConnected() bool {
-	return n.connected
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.state == Connected
 }
 
 func (n *Network) Connect(ctx context.Context) (*ethclient.Client, error) {
-	client, err := ethclient.Dial(n.RpcUrl)
+	rpcClient, err := rpc.DialContext(ctx, n.RpcUrl)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot connect to %s: %s\n", n.RpcUrl, err)
 	}
-	n.connected = true
+	if n.HealthInterval == 0 {
+		n.HealthInterval = DefaultHealthInterval
+	}
+	if n.MinBackoff == 0 {
+		n.MinBackoff = DefaultMinBackoff
+	}
+	if n.MaxBackoff == 0 {
+		n.MaxBackoff = DefaultMaxBackoff
+	}
+
+	client := ethclient.NewClient(rpcClient)
+	_, err = client.ChainID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot connect to %s: %s\n", n.RpcUrl, err)
+	}
+	endpoints := []*Endpoint{newEndpoint(n.RpcUrl, client)}
+
+	for _, url := range n.ExtraUrls {
+		extra, err := ethclient.DialContext(ctx, url)
+		if err != nil {
+			log.Printf("Skipping RPC endpoint %s: %s", url, err)
+			continue
+		}
+		endpoints = append(endpoints, newEndpoint(url, extra))
+	}
+
+	n.mu.Lock()
+	n.rpc = rpcClient
+	n.endpoints = endpoints
+	n.mu.Unlock()
+	n.setState(Connected)
+
+	go n.watch(ctx)
+	go n.watchExtraEndpoints(ctx)
+	return client, nil
+}
+
+// Rpc returns the underlying client, shared by everything using the network.
+func (n *Network) Rpc() *rpc.Client {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.rpc
+}
+
+// States returns a stream of connection state changes. Changes are dropped
+// while the receiver is busy.
+func (n *Network) States() <-chan ConnState {
+	ch := make(chan ConnState, 1)
 
-	return client, err
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.listeners = append(n.listeners, ch)
+
+	return ch
+}
+
+// Subscribe keeps the subscription made by subscribe alive, making it again
+// with backoff every time it fails.
+func (n *Network) Subscribe(name string, subscribe func(ctx context.Context) (event.Subscription, error)) event.Subscription {
+	return event.ResubscribeErr(n.MaxBackoff, func(ctx context.Context, lastErr error) (event.Subscription, error) {
+		if lastErr != nil {
+			log.Printf("%s subscription failed, resubscribing: %s", name, lastErr)
+			n.setState(Disconnected)
+		}
+		sub, err := subscribe(ctx)
+		if err != nil {
+			log.Printf("Failed to subscribe to %s: %s", name, err)
+			n.setState(Disconnected)
+			return nil, err
+		}
+		n.setState(Connected)
+		return sub, nil
+	})
+}
+
+func (n *Network) setState(state ConnState) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.state == state {
+		return
+	}
+	n.state = state
+
+	for _, ch := range n.listeners {
+		select {
+		case ch <- state:
+		default:
+		}
+	}
+}
+
+// watch pings the node every HealthInterval, retrying with exponential
+// backoff while it does not answer.
+func (n *Network) watch(ctx context.Context) {
+	wait := n.HealthInterval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, n.HealthInterval)
+		err := n.Endpoints()[0].ping(pingCtx)
+		cancel()
+		if err == nil {
+			n.setState(Connected)
+			wait = n.HealthInterval
+			continue
+		}
+
+		if n.IsConnected() {
+			log.Printf("Lost connection to %s: %s", n.RpcUrl, err)
+			n.setState(Disconnected)
+			wait = n.MinBackoff
+			continue
+		}
+		wait *= 2
+		if wait > n.MaxBackoff {
+			wait = n.MaxBackoff
+		}
+	}
+}
+
+// watchExtraEndpoints measures the latency of the extra endpoints, which only
+// serve reads and broadcasts and need no reconnection.
+func (n *Network) watchExtraEndpoints(ctx context.Context) {
+	extra := n.Endpoints()[1:]
+	if len(extra) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(n.HealthInterval)
+	defer ticker.Stop()
+	for {
+		for _, e := range extra {
+			pingCtx, cancel := context.WithTimeout(ctx, n.HealthInterval)
+			err := e.ping(pingCtx)
+			cancel()
+			if err != nil {
+				log.Printf("RPC endpoint %s is unhealthy: %s", e.Url, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
@@ -0,0 +1,71 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+type tickService struct {
+	tick int
+}
+
+func (s *tickService) Ticks(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+	go notifier.Notify(sub.ID, s.tick)
+	return sub, nil
+}
+
+func TestNetworkSubscribe(t *testing.T) {
+	var servers []*rpc.Server
+	for tick := 1; tick <= 2; tick++ {
+		server := rpc.NewServer()
+		assert.Nil(t, server.RegisterName("test", &tickService{tick: tick}))
+		defer server.Stop()
+		servers = append(servers, server)
+	}
+
+	n := &Network{MaxBackoff: 10 * time.Millisecond}
+	ticks := make(chan int)
+	var attempts int32
+	sub := n.Subscribe("ticks", func(ctx context.Context) (event.Subscription, error) {
+		switch atomic.AddInt32(&attempts, 1) {
+		case 1:
+			return nil, errors.New("node unreachable")
+		case 2:
+			return rpc.DialInProc(servers[0]).Subscribe(ctx, "test", ticks, "ticks")
+		default:
+			return rpc.DialInProc(servers[1]).Subscribe(ctx, "test", ticks, "ticks")
+		}
+	})
+	defer sub.Unsubscribe()
+
+	receive := func() int {
+		select {
+		case tick := <-ticks:
+			return tick
+		case <-time.After(time.Second):
+			t.Fatal("no tick received")
+			return 0
+		}
+	}
+
+	assert.Equal(t, 1, receive(), "a failed subscription should be retried")
+	assert.Eventually(t, n.IsConnected, time.Second, time.Millisecond)
+
+	// the connection dropping ends the subscription
+	servers[0].Stop()
+	assert.Equal(t, 2, receive(), "a dropped subscription should be made again")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	assert.Eventually(t, n.IsConnected, time.Second, time.Millisecond)
+}
@@ -0,0 +1,235 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const DefaultNonceCheckInterval = 30 * time.Second
+
+// NonceManager hands out the nonces of a wallet without asking the node for
+// each transaction. Nonces handed out stay reserved until the transaction
+// using them is sent, and released nonces are handed out again, lowest first,
+// so that no gap is left behind.
+type NonceManager struct {
+	address common.Address
+
+	mu       sync.Mutex
+	synced   bool
+	next     uint64
+	reserved map[uint64]struct{}
+	released []uint64
+}
+
+func NewNonceManager(address common.Address) *NonceManager {
+	return &NonceManager{
+		address:  address,
+		reserved: make(map[uint64]struct{}),
+	}
+}
+
+// Sync restarts handing out nonces from the pending nonce of the node,
+// forgetting the released ones. Nonces still reserved at or above it are kept
+// and skipped, their transactions may still be sent.
+func (m *NonceManager) Sync(client *ethclient.Client, ctx context.Context) error {
+	nonce, err := client.PendingNonceAt(ctx, m.address)
+	if err != nil {
+		return fmt.Errorf("Failed to get pending nonce: %s", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resync(nonce)
+	return nil
+}
+
+func (m *NonceManager) resync(nonce uint64) {
+	m.next = nonce
+	m.synced = true
+	for n := range m.reserved {
+		if n < nonce {
+			delete(m.reserved, n)
+		}
+	}
+	m.released = nil
+}
+
+// Next reserves the lowest nonce available.
+func (m *NonceManager) Next(client *ethclient.Client, ctx context.Context) (uint64, error) {
+	err := m.syncOnce(client, ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	nonce := m.peek()
+	m.claim(nonce)
+	return nonce, nil
+}
+
+// Peek returns the nonce Next would hand out, without reserving it.
+func (m *NonceManager) Peek(client *ethclient.Client, ctx context.Context) (uint64, error) {
+	err := m.syncOnce(client, ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.peek(), nil
+}
+
+func (m *NonceManager) syncOnce(client *ethclient.Client, ctx context.Context) error {
+	m.mu.Lock()
+	synced := m.synced
+	m.mu.Unlock()
+	if synced {
+		return nil
+	}
+	return m.Sync(client, ctx)
+}
+
+// Claim reserves the nonce if it is the one Next would hand out, telling
+// whether it was.
+func (m *NonceManager) Claim(nonce uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.claim(nonce)
+}
+
+func (m *NonceManager) peek() uint64 {
+	if len(m.released) > 0 {
+		return m.released[0]
+	}
+	for {
+		if _, reserved := m.reserved[m.next]; !reserved {
+			return m.next
+		}
+		m.next++
+	}
+}
+
+func (m *NonceManager) claim(nonce uint64) bool {
+	if m.peek() != nonce {
+		return false
+	}
+	if len(m.released) > 0 {
+		m.released = m.released[1:]
+	} else {
+		m.next++
+	}
+	m.reserved[nonce] = struct{}{}
+	return true
+}
+
+// Sent marks the nonce as used by a transaction known to the node.
+func (m *NonceManager) Sent(nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.reserved, nonce)
+}
+
+// Release gives back a nonce whose transaction was never sent or got dropped.
+func (m *NonceManager) Release(nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.release(nonce)
+}
+
+func (m *NonceManager) release(nonce uint64) {
+	delete(m.reserved, nonce)
+	if nonce >= m.next {
+		return
+	}
+	for _, n := range m.released {
+		if n == nonce {
+			return
+		}
+	}
+	m.released = append(m.released, nonce)
+	sort.Slice(m.released, func(i, j int) bool { return m.released[i] < m.released[j] })
+}
+
+// HandleSendError resyncs after the node refused a transaction for its
+// nonce, telling whether it did.
+func (m *NonceManager) HandleSendError(client *ethclient.Client, ctx context.Context, err error) bool {
+	if !IsNonceError(err) {
+		return false
+	}
+	log.Printf("Resyncing nonces of %s: %s", m.address.Hex(), err)
+	syncErr := m.Sync(client, ctx)
+	if syncErr != nil {
+		log.Printf("Failed to resync nonces: %s", syncErr)
+	}
+	return true
+}
+
+func IsNonceError(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "nonce too low")
+}
+
+// CheckGaps compares the local nonces with the pending nonce of the node. A
+// node nonce above the local one means transactions were sent from elsewhere,
+// and one below it that a nonce handed out never reached the node and has to
+// be filled again.
+func (m *NonceManager) CheckGaps(client *ethclient.Client, ctx context.Context) error {
+	nonce, err := client.PendingNonceAt(ctx, m.address)
+	if err != nil {
+		return fmt.Errorf("Failed to get pending nonce: %s", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.synced {
+		return nil
+	}
+
+	if nonce > m.next {
+		log.Printf("Nonce of %s moved to %d outside of the sniper, resyncing", m.address.Hex(), nonce)
+		m.resync(nonce)
+		return nil
+	}
+
+	_, reserved := m.reserved[nonce]
+	if nonce < m.next && !reserved && !m.isReleased(nonce) {
+		log.Printf("Nonce %d of %s was handed out but never reached the node, releasing it", nonce, m.address.Hex())
+		m.release(nonce)
+	}
+	return nil
+}
+
+func (m *NonceManager) isReleased(nonce uint64) bool {
+	for _, n := range m.released {
+		if n == nonce {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch checks for nonce gaps every interval until ctx is done.
+func (m *NonceManager) Watch(client *ethclient.Client, ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := m.CheckGaps(client, ctx)
+			if err != nil {
+				log.Printf("Failed to check nonce gaps: %s", err)
+			}
+		}
+	}
+}
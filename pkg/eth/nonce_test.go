@@ -0,0 +1,74 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+type nonceNode struct {
+	nonce uint64
+}
+
+func (n *nonceNode) GetTransactionCount(address common.Address, block string) hexutil.Uint64 {
+	return hexutil.Uint64(n.nonce)
+}
+
+func TestNonceManager(t *testing.T) {
+	node := &nonceNode{nonce: 5}
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("eth", node))
+	defer server.Stop()
+	client := ethclient.NewClient(rpc.DialInProc(server))
+	ctx := context.Background()
+
+	m := NewNonceManager(common.HexToAddress("0x1"))
+	for _, expected := range []uint64{5, 6, 7} {
+		nonce, err := m.Next(client, ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, nonce)
+	}
+
+	m.Release(6)
+	nonce, _ := m.Next(client, ctx)
+	assert.Equal(t, uint64(6), nonce, "released nonces should be handed out first")
+	nonce, _ = m.Next(client, ctx)
+	assert.Equal(t, uint64(8), nonce)
+
+	m.Sent(5)
+	m.Sent(6)
+	m.Sent(8)
+	node.nonce = 7
+	assert.Nil(t, m.CheckGaps(client, ctx))
+	nonce, _ = m.Next(client, ctx)
+	assert.Equal(t, uint64(9), nonce, "a nonce still reserved is not a gap")
+
+	m.Sent(7)
+	assert.Nil(t, m.CheckGaps(client, ctx))
+	nonce, _ = m.Next(client, ctx)
+	assert.Equal(t, uint64(7), nonce, "a sent nonce unknown to the node should be filled again")
+
+	reserved, _ := m.Next(client, ctx)
+	assert.Equal(t, uint64(10), reserved)
+	node.nonce = 8
+	assert.True(t, m.HandleSendError(client, ctx, errors.New("nonce too low: next nonce 8, tx nonce 7")))
+	for _, expected := range []uint64{8, 11} {
+		nonce, _ = m.Next(client, ctx)
+		assert.Equal(t, expected, nonce, "a resync should not hand out a nonce still reserved")
+	}
+
+	node.nonce = 20
+	assert.True(t, m.HandleSendError(client, ctx, errors.New("nonce too low: next nonce 20, tx nonce 7")))
+	peeked, _ := m.Peek(client, ctx)
+	assert.Equal(t, uint64(20), peeked)
+	assert.False(t, m.Claim(21), "only the next nonce can be claimed")
+	assert.True(t, m.Claim(20))
+	nonce, _ = m.Next(client, ctx)
+	assert.Equal(t, uint64(21), nonce)
+}
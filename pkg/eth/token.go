@@ -20,7 +20,7 @@ type Token struct {
 	Symbol string
 }
 
-func NewToken(client *ethclient.Client, address common.Address) (*Token, error) {
+func NewToken(client bind.ContractBackend, address common.Address) (*Token, error) {
 	var err error
 
 	tokenContract, err := NewContract(address, tokens.Erc20TokenMetaData)
@@ -53,6 +53,21 @@ func NewToken(client *ethclient.Client, address common.Address) (*Token, error)
 	return t, nil
 }
 
+// GetOwner reads the owner of an ownable token, the zero address when it
+// has none or renounced ownership.
+func (t *Token) GetOwner(client *ethclient.Client, ctx context.Context) (common.Address, error) {
+	for _, getter := range []string{"owner", "getOwner"} {
+		out, err := t.callGetter(client, ctx, getter)
+		if err != nil {
+			return common.Address{}, err
+		}
+		if len(out) == 32 {
+			return common.BytesToAddress(out), nil
+		}
+	}
+	return common.Address{}, nil
+}
+
 func (t *Token) PrintBalanceAt(ctx context.Context, addr common.Address, pending bool) error {
 	var err error
 	opts := &bind.CallOpts{
@@ -0,0 +1,100 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Getters launch tokens commonly expose their anti-whale limits through.
+var (
+	maxTxGetters = []string{
+		"_maxTxAmount", "maxTxAmount", "maxTransactionAmount", "_maxTransactionAmount",
+		"maxTxnAmount", "maxBuyAmount", "_maxBuyAmount",
+	}
+	maxWalletGetters = []string{
+		"_maxWalletSize", "maxWalletSize", "_maxWalletAmount", "maxWalletAmount",
+		"maxWallet", "_maxWalletToken", "maxWalletToken",
+	}
+)
+
+// TokenLimits are the most a token lets a single transfer move and a holder
+// own, nil when the token exposes no such limit.
+type TokenLimits struct {
+	MaxTx     *big.Int
+	MaxWallet *big.Int
+}
+
+// GetLimits reads the limits of the token through the first of the common
+// getters it answers to.
+func (t *Token) GetLimits(client *ethclient.Client, ctx context.Context) (*TokenLimits, error) {
+	maxTx, err := t.callLimit(client, ctx, maxTxGetters)
+	if err != nil {
+		return nil, err
+	}
+	maxWallet, err := t.callLimit(client, ctx, maxWalletGetters)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenLimits{MaxTx: maxTx, MaxWallet: maxWallet}, nil
+}
+
+func (t *Token) callLimit(client *ethclient.Client, ctx context.Context, getters []string) (*big.Int, error) {
+	for _, getter := range getters {
+		out, err := t.callGetter(client, ctx, getter)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) != 32 {
+			continue
+		}
+		// zero and the max uint256 both stand for no limit
+		limit := new(big.Int).SetBytes(out)
+		if limit.Sign() == 0 || limit.Cmp(math.MaxBig256) == 0 {
+			continue
+		}
+		return limit, nil
+	}
+	return nil, nil
+}
+
+// callGetter calls a getter without arguments which the token may not have,
+// returning no output when it reverts.
+func (t *Token) callGetter(client *ethclient.Client, ctx context.Context, getter string) ([]byte, error) {
+	msg := ethereum.CallMsg{To: &t.Address, Data: crypto.Keccak256([]byte(getter + "()"))[:4]}
+	out, err := client.PendingCallContract(ctx, msg)
+	if IsRevert(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to call %s of %s: %s", getter, t.Symbol, err)
+	}
+	return out, nil
+}
+
+// MaxBuy is the most a holder of balance can buy, nil without limits.
+func (l *TokenLimits) MaxBuy(balance *big.Int) *big.Int {
+	max := l.MaxTx
+	if l.MaxWallet != nil {
+		room := new(big.Int).Sub(l.MaxWallet, balance)
+		if room.Sign() < 0 {
+			room.SetInt64(0)
+		}
+		if max == nil || room.Cmp(max) < 0 {
+			max = room
+		}
+	}
+	return max
+}
+
+// IsRevert tells whether a call failed because its execution reverted, which
+// nodes only report in the error message.
+func IsRevert(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "revert")
+}
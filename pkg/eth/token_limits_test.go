@@ -0,0 +1,54 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+// limitsNode answers the getters it holds a value for and reverts the others.
+type limitsNode struct {
+	getters map[string]*big.Int
+}
+
+func (n *limitsNode) Call(args map[string]interface{}, block string) (hexutil.Bytes, error) {
+	data, _ := hexutil.Decode(args["data"].(string))
+	for getter, value := range n.getters {
+		if string(crypto.Keccak256([]byte(getter + "()"))[:4]) == string(data) {
+			return common.BigToHash(value).Bytes(), nil
+		}
+	}
+	return nil, errors.New("execution reverted")
+}
+
+func TestGetLimits(t *testing.T) {
+	node := &limitsNode{getters: map[string]*big.Int{
+		"maxTxAmount":   big.NewInt(0),
+		"_maxTxAmount":  big.NewInt(1000),
+		"maxWalletSize": big.NewInt(2500),
+	}}
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("eth", node))
+	defer server.Stop()
+	client := ethclient.NewClient(rpc.DialInProc(server))
+
+	token := &Token{Contract: &Contract{Address: common.HexToAddress("0x1")}, Symbol: "TKN"}
+	limits, err := token.GetLimits(client, context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "1000", limits.MaxTx.String())
+	assert.Equal(t, "2500", limits.MaxWallet.String())
+
+	assert.Equal(t, "1000", limits.MaxBuy(big.NewInt(0)).String())
+	assert.Equal(t, "500", limits.MaxBuy(big.NewInt(2000)).String(), "max wallet should leave room for the balance")
+	assert.Equal(t, "0", limits.MaxBuy(big.NewInt(3000)).String())
+
+	assert.Nil(t, (&TokenLimits{}).MaxBuy(big.NewInt(0)), "no limits should mean no max")
+}
@@ -0,0 +1,114 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+)
+
+const MaxStorageSlot = 64
+
+var (
+	PendingBlock = big.NewInt(-1)
+	probeValue   = new(big.Int).SetBytes(crypto.Keccak256([]byte("sniper.probe"))[:16])
+)
+
+// TokenSlots locates the balance and allowance mappings in the storage of an
+// ERC20 contract laid out by Solidity, so that both can be overridden when
+// simulating calls.
+type TokenSlots struct {
+	Balance   *big.Int
+	Allowance *big.Int
+}
+
+func mappingKey(key common.Hash, slot *big.Int) common.Hash {
+	return crypto.Keccak256Hash(key.Bytes(), common.BigToHash(slot).Bytes())
+}
+
+func (s *TokenSlots) BalanceKey(holder common.Address) common.Hash {
+	return mappingKey(holder.Hash(), s.Balance)
+}
+
+func (s *TokenSlots) AllowanceKey(owner, spender common.Address) common.Hash {
+	inner := mappingKey(owner.Hash(), s.Allowance)
+	return crypto.Keccak256Hash(spender.Hash().Bytes(), inner.Bytes())
+}
+
+// StateOverride builds an override of the token storage. Overrides sent
+// through gethclient always replace the account code as well, so the current
+// code is sent along.
+func (t *Token) StateOverride(client *ethclient.Client, ctx context.Context, state map[common.Hash]common.Hash) (gethclient.OverrideAccount, error) {
+	code, err := client.PendingCodeAt(ctx, t.Address)
+	if err != nil {
+		return gethclient.OverrideAccount{}, fmt.Errorf("Failed to get code of %s: %s", t.Symbol, err)
+	}
+	return gethclient.OverrideAccount{Code: code, StateDiff: state}, nil
+}
+
+// FindStorageSlots probes the first MaxStorageSlot slots of the token for the
+// ones whose mapping entries change balanceOf and allowance.
+func (t *Token) FindStorageSlots(client *ethclient.Client, geth *gethclient.Client, ctx context.Context, owner, spender common.Address) (*TokenSlots, error) {
+	code, err := client.PendingCodeAt(ctx, t.Address)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get code of %s: %s", t.Symbol, err)
+	}
+	slots := &TokenSlots{}
+
+	for i := int64(0); i < MaxStorageSlot && (slots.Balance == nil || slots.Allowance == nil); i++ {
+		slot := big.NewInt(i)
+
+		if slots.Balance == nil {
+			probe := &TokenSlots{Balance: slot}
+			ok, err := t.probeSlot(geth, ctx, code, probe.BalanceKey(owner), "balanceOf", owner)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				slots.Balance = slot
+			}
+		}
+		if slots.Allowance == nil {
+			probe := &TokenSlots{Allowance: slot}
+			ok, err := t.probeSlot(geth, ctx, code, probe.AllowanceKey(owner, spender), "allowance", owner, spender)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				slots.Allowance = slot
+			}
+		}
+	}
+
+	if slots.Balance == nil || slots.Allowance == nil {
+		return nil, errors.New("balance and allowance storage slots not found")
+	}
+	return slots, nil
+}
+
+func (t *Token) probeSlot(geth *gethclient.Client, ctx context.Context, code []byte, key common.Hash, method string, args ...interface{}) (bool, error) {
+	data, err := t.ABI.Pack(method, args...)
+	if err != nil {
+		return false, err
+	}
+
+	overrides := map[common.Address]gethclient.OverrideAccount{
+		t.Address: {
+			Code:      code,
+			StateDiff: map[common.Hash]common.Hash{key: common.BigToHash(probeValue)},
+		},
+	}
+
+	msg := ethereum.CallMsg{To: &t.Address, Data: data}
+	out, err := geth.CallContract(ctx, msg, PendingBlock, &overrides)
+	if err != nil {
+		return false, fmt.Errorf("Failed to call %s of %s: %s", method, t.Symbol, err)
+	}
+	return new(big.Int).SetBytes(out).Cmp(probeValue) == 0, nil
+}
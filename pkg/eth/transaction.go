@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
@@ -32,13 +33,45 @@ func SendTx(client *ethclient.Client, ctx context.Context, tx *types.Transaction
 	log.Printf("Transaction mined: %s\n%s\n", tx.Hash().Hex(), string(b))
 }
 
+// GetEffectiveGasPrice is the price per gas actually paid by a mined
+// transaction. Dynamic fee transactions pay the base fee of their block plus
+// their tip, capped by their fee cap.
+func GetEffectiveGasPrice(client *ethclient.Client, ctx context.Context, tx *types.Transaction, receipt *types.Receipt) (*big.Int, error) {
+	if tx.Type() == types.LegacyTxType || tx.Type() == types.AccessListTxType {
+		return tx.GasPrice(), nil
+	}
+
+	head, err := client.HeaderByNumber(ctx, receipt.BlockNumber)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get header of block %s: %s", receipt.BlockNumber, err)
+	}
+	if head.BaseFee == nil {
+		return tx.GasPrice(), nil
+	}
+
+	tip, err := tx.EffectiveGasTip(head.BaseFee)
+	if err != nil {
+		return nil, err
+	}
+	return tip.Add(tip, head.BaseFee), nil
+}
+
+func GetTxGasFees(client *ethclient.Client, ctx context.Context, tx *types.Transaction, receipt *types.Receipt) (*big.Int, error) {
+	gasPrice, err := GetEffectiveGasPrice(client, ctx, tx, receipt)
+	if err != nil {
+		return nil, err
+	}
+	gasUsed := new(big.Int).SetUint64(receipt.GasUsed)
+	return gasUsed.Mul(gasUsed, gasPrice), nil
+}
+
 func GetTxSender(client *ethclient.Client, tx *types.Transaction) (*common.Address, error) {
 	chainID, err := client.NetworkID(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get network Chain ID: %s", err)
 	}
 
-	msg, err := tx.AsMessage(types.NewEIP155Signer(chainID), nil)
+	msg, err := tx.AsMessage(types.LatestSignerForChainID(chainID), nil)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get transaction as message: %s", err)
 	}
@@ -50,6 +83,9 @@ func GetTxSender(client *ethclient.Client, tx *types.Transaction) (*common.Addre
 func GetTxCallData(contractABI abi.ABI, tx *types.Transaction) (method *abi.Method, args map[string]interface{}, err error) {
 	callData := tx.Data()
 	args = make(map[string]interface{}, 0)
+	if len(callData) < 4 {
+		return nil, nil, fmt.Errorf("transaction has no method call")
+	}
 
 	method, err = contractABI.MethodById(callData[:4])
 	if err != nil {
@@ -0,0 +1,368 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// TrackedTx follows a transaction and every replacement sent at its nonce,
+// so that whichever gets mined is attributed to the original.
+type TrackedTx struct {
+	Original *types.Transaction
+	Mined    *types.Transaction
+	Receipt  *types.Receipt
+
+	mu     sync.Mutex
+	sent   []*types.Transaction
+	cancel *types.Transaction
+}
+
+// Cancelled tells whether the cancel transaction was mined instead of the
+// original one.
+func (t *TrackedTx) Cancelled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cancel != nil && t.Mined != nil && t.Mined.Hash() == t.cancel.Hash()
+}
+
+func (t *TrackedTx) Latest() *types.Transaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sent[len(t.sent)-1]
+}
+
+func (t *TrackedTx) Hashes() []common.Hash {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hashes := make([]common.Hash, len(t.sent))
+	for i, tx := range t.sent {
+		hashes[i] = tx.Hash()
+	}
+	return hashes
+}
+
+func (t *TrackedTx) sentTxs() []*types.Transaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*types.Transaction{}, t.sent...)
+}
+
+func (t *TrackedTx) cancelSent() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cancel != nil
+}
+
+func (t *TrackedTx) add(tx *types.Transaction) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, tx)
+}
+
+// TxManager sends transactions and waits for them to be mined, replacing them
+// with bumped fees after BumpAfterBlocks blocks without inclusion. Once
+// MaxBumps replacements were sent, stuck transactions are cancelled when
+// CancelStuck is set. Transactions are broadcast through Sender and their
+// receipts read through Reads, both the client unless told otherwise, and
+// OnReplace is told about every replacement sent, cancellations included.
+type TxManager struct {
+	BumpAfterBlocks uint64
+	BumpPercent     int64
+	MaxBumps        int
+	CancelStuck     bool
+	GasCap          *big.Int
+	Sender          TxSender
+	Reads           ClientSource
+	OnReplace       func(replaced, replacement *types.Transaction)
+
+	client *ethclient.Client
+	wallet *Wallet
+}
+
+var ErrTxDropped = errors.New("transaction dropped from the mempool")
+
+const (
+	DefaultBumpAfterBlocks = 3
+	// nodes only accept replacements paying at least 10% more
+	DefaultBumpPercent = 12
+	DefaultMaxBumps    = 5
+	// polls during which the node must not know any of the sent transactions
+	// before they are considered dropped
+	droppedAfterPolls = 10
+)
+
+func NewTxManager(client *ethclient.Client, wallet *Wallet) *TxManager {
+	return &TxManager{
+		BumpAfterBlocks: DefaultBumpAfterBlocks,
+		BumpPercent:     DefaultBumpPercent,
+		MaxBumps:        DefaultMaxBumps,
+		Sender:          client,
+		Reads:           fixedClient{client},
+		client:          client,
+		wallet:          wallet,
+	}
+}
+
+// Send broadcasts a signed transaction and blocks until it, or one of its
+// replacements, is mined.
+func (m *TxManager) Send(ctx context.Context, tx *types.Transaction) (*TrackedTx, error) {
+	t, err := m.Broadcast(ctx, tx)
+	if err != nil {
+		return t, err
+	}
+
+	err = m.Wait(ctx, t)
+	return t, err
+}
+
+// Broadcast sends a signed transaction and starts tracking it, without
+// waiting for it to be mined. The nonce of a transaction the node refused is
+// given back to the wallet.
+func (m *TxManager) Broadcast(ctx context.Context, tx *types.Transaction) (*TrackedTx, error) {
+	t := &TrackedTx{Original: tx}
+	err := m.broadcast(ctx, t, tx)
+	if err != nil {
+		if !m.wallet.Nonces.HandleSendError(m.client, ctx, err) {
+			m.wallet.Nonces.Release(tx.Nonce())
+		}
+		return t, err
+	}
+	m.wallet.Nonces.Sent(tx.Nonce())
+	return t, nil
+}
+
+// Cancel replaces a tracked transaction with a zero value transfer to the
+// wallet itself at the same nonce.
+func (m *TxManager) Cancel(ctx context.Context, t *TrackedTx) error {
+	latest := t.Latest()
+	cancel, err := m.bump(latest, func(fees *GasFees) types.TxData {
+		to := m.wallet.Address()
+		if fees.IsDynamic() {
+			return &types.DynamicFeeTx{
+				ChainID:   latest.ChainId(),
+				Nonce:     latest.Nonce(),
+				GasTipCap: fees.GasTipCap,
+				GasFeeCap: fees.GasFeeCap,
+				Gas:       params.TxGas,
+				To:        &to,
+				Value:     big.NewInt(0),
+			}
+		}
+		return &types.LegacyTx{
+			Nonce:    latest.Nonce(),
+			GasPrice: fees.GasPrice,
+			Gas:      params.TxGas,
+			To:       &to,
+			Value:    big.NewInt(0),
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to build cancel transaction: %s", err)
+	}
+
+	err = m.broadcast(ctx, t, cancel)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.cancel = cancel
+	t.mu.Unlock()
+	log.Printf("Cancelling transaction %s with %s", t.Original.Hash().Hex(), cancel.Hash().Hex())
+	if m.OnReplace != nil {
+		m.OnReplace(latest, cancel)
+	}
+	return nil
+}
+
+// SpeedUp replaces the latest transaction sent at the tracked nonce with a copy
+// paying BumpPercent more.
+func (m *TxManager) SpeedUp(ctx context.Context, t *TrackedTx) error {
+	latest := t.Latest()
+	replacement, err := m.bump(latest, func(fees *GasFees) types.TxData {
+		if fees.IsDynamic() {
+			return &types.DynamicFeeTx{
+				ChainID:    latest.ChainId(),
+				Nonce:      latest.Nonce(),
+				GasTipCap:  fees.GasTipCap,
+				GasFeeCap:  fees.GasFeeCap,
+				Gas:        latest.Gas(),
+				To:         latest.To(),
+				Value:      latest.Value(),
+				Data:       latest.Data(),
+				AccessList: latest.AccessList(),
+			}
+		}
+		return &types.LegacyTx{
+			Nonce:    latest.Nonce(),
+			GasPrice: fees.GasPrice,
+			Gas:      latest.Gas(),
+			To:       latest.To(),
+			Value:    latest.Value(),
+			Data:     latest.Data(),
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to build replacement transaction: %s", err)
+	}
+
+	err = m.broadcast(ctx, t, replacement)
+	if err != nil {
+		return err
+	}
+	log.Printf("Replaced transaction %s with %s", latest.Hash().Hex(), replacement.Hash().Hex())
+	if m.OnReplace != nil {
+		m.OnReplace(latest, replacement)
+	}
+	return nil
+}
+
+func (m *TxManager) broadcast(ctx context.Context, t *TrackedTx, tx *types.Transaction) error {
+	err := m.Sender.SendTransaction(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("Failed to send transaction: %s", err)
+	}
+	t.add(tx)
+	log.Printf("Transaction sent: %s", tx.Hash().Hex())
+	return nil
+}
+
+// Wait blocks until the tracked transaction, or one of its replacements, is
+// mined, bumping its fees while it is stuck. When the node forgets every
+// transaction sent at the nonce, the nonce is released and ErrTxDropped
+// returned.
+func (m *TxManager) Wait(ctx context.Context, t *TrackedTx) error {
+	client := m.Reads.Client()
+	sentAt, err := client.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to get block number: %s", err)
+	}
+	bumps := 0
+	missing := 0
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		client = m.Reads.Client()
+		nonce, nonceErr := client.NonceAt(ctx, m.wallet.Address(), nil)
+
+		for _, tx := range t.sentTxs() {
+			receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+			if err == nil {
+				t.mu.Lock()
+				t.Mined = tx
+				t.Receipt = receipt
+				t.mu.Unlock()
+				if tx.Hash() != t.Original.Hash() {
+					log.Printf("Transaction %s mined as %s", t.Original.Hash().Hex(), tx.Hash().Hex())
+				}
+				return nil
+			}
+			if !errors.Is(err, ethereum.NotFound) {
+				log.Printf("Failed to get receipt of %s: %s", tx.Hash().Hex(), err)
+			}
+		}
+		if nonceErr == nil && nonce > t.Original.Nonce() {
+			return fmt.Errorf("nonce %d of transaction %s was used by an unknown transaction", t.Original.Nonce(), t.Original.Hash().Hex())
+		}
+
+		if m.isKnown(ctx, t) {
+			missing = 0
+		} else {
+			missing++
+		}
+		if missing >= droppedAfterPolls {
+			m.wallet.Nonces.Release(t.Original.Nonce())
+			return fmt.Errorf("%w: %s", ErrTxDropped, t.Original.Hash().Hex())
+		}
+
+		block, err := client.BlockNumber(ctx)
+		if err == nil && m.BumpAfterBlocks > 0 && block >= sentAt+m.BumpAfterBlocks {
+			switch {
+			case bumps < m.MaxBumps:
+				err = m.SpeedUp(ctx, t)
+				bumps++
+			case m.CancelStuck && !t.cancelSent():
+				err = m.Cancel(ctx, t)
+			}
+			if err != nil {
+				log.Printf("Failed to replace stuck transaction: %s", err)
+			}
+			sentAt = block
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// isKnown tells whether the node still has one of the transactions sent at
+// the tracked nonce. Errors other than not found count as known.
+func (m *TxManager) isKnown(ctx context.Context, t *TrackedTx) bool {
+	for _, tx := range t.sentTxs() {
+		_, _, err := m.Reads.Client().TransactionByHash(ctx, tx.Hash())
+		if !errors.Is(err, ethereum.NotFound) {
+			return true
+		}
+	}
+	return false
+}
+
+// bump signs the transaction built by newTx with the fees of tx raised by
+// BumpPercent, refusing to go over GasCap.
+func (m *TxManager) bump(tx *types.Transaction, newTx func(fees *GasFees) types.TxData) (*types.Transaction, error) {
+	var fees *GasFees
+	if tx.Type() == types.DynamicFeeTxType {
+		fees = &GasFees{
+			GasFeeCap: m.bumpPrice(tx.GasFeeCap()),
+			GasTipCap: m.bumpPrice(tx.GasTipCap()),
+		}
+		if fees.GasFeeCap.Cmp(minReplacementPrice(tx.GasFeeCap())) < 0 {
+			return nil, errors.New("gas fee cap cannot be bumped under the gas cap")
+		}
+		if fees.GasTipCap.Cmp(fees.GasFeeCap) > 0 {
+			fees.GasTipCap = new(big.Int).Set(fees.GasFeeCap)
+		}
+		// nodes check the tip as well, a capped one gets refused as underpriced
+		if fees.GasTipCap.Cmp(minReplacementPrice(tx.GasTipCap())) < 0 {
+			return nil, errors.New("gas tip cannot be bumped under the gas cap")
+		}
+	} else {
+		fees = &GasFees{GasPrice: m.bumpPrice(tx.GasPrice())}
+		if fees.GasPrice.Cmp(minReplacementPrice(tx.GasPrice())) < 0 {
+			return nil, errors.New("gas price cannot be bumped under the gas cap")
+		}
+	}
+
+	opts, err := m.wallet.GetSignerOpts()
+	if err != nil {
+		return nil, err
+	}
+	return opts.Signer(opts.From, types.NewTx(newTx(fees)))
+}
+
+func (m *TxManager) bumpPrice(price *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(price, big.NewInt(100+m.BumpPercent))
+	bumped.Div(bumped, big.NewInt(100))
+	return capGasPrice(bumped, m.GasCap)
+}
+
+func minReplacementPrice(price *big.Int) *big.Int {
+	min := new(big.Int).Mul(price, big.NewInt(110))
+	return min.Div(min, big.NewInt(100))
+}
@@ -0,0 +1,175 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+// txNode mines the replacement of a transaction as soon as it is sent, and
+// moves a block forward on every block number asked.
+type txNode struct {
+	mu    sync.Mutex
+	block uint64
+	sent  []*types.Transaction
+	mined map[common.Hash]bool
+}
+
+func (n *txNode) SendRawTransaction(raw hexutil.Bytes) (common.Hash, error) {
+	tx := new(types.Transaction)
+	err := tx.UnmarshalBinary(raw)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.sent) > 0 {
+		n.mined[tx.Hash()] = true
+	}
+	n.sent = append(n.sent, tx)
+	return tx.Hash(), nil
+}
+
+func (n *txNode) BlockNumber() hexutil.Uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.block++
+	return hexutil.Uint64(n.block)
+}
+
+func (n *txNode) GetTransactionCount(address common.Address, block string) hexutil.Uint64 {
+	return 0
+}
+
+func (n *txNode) GetTransactionReceipt(hash common.Hash) *types.Receipt {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.mined[hash] {
+		return nil
+	}
+	return &types.Receipt{Status: types.ReceiptStatusSuccessful, TxHash: hash, Logs: []*types.Log{}, BlockNumber: big.NewInt(int64(n.block))}
+}
+
+func (n *txNode) GetTransactionByHash(hash common.Hash) *types.Transaction {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, tx := range n.sent {
+		if tx.Hash() == hash {
+			return tx
+		}
+	}
+	return nil
+}
+
+func newTestTxManager(t *testing.T) (*TxManager, *txNode, *Wallet) {
+	node := &txNode{mined: make(map[common.Hash]bool)}
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("eth", node))
+	t.Cleanup(server.Stop)
+	client := ethclient.NewClient(rpc.DialInProc(server))
+
+	wallet, err := NewWallet("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318", 56)
+	assert.Nil(t, err)
+	return NewTxManager(client, wallet), node, wallet
+}
+
+func signTestTx(t *testing.T, wallet *Wallet, data types.TxData) *types.Transaction {
+	opts, err := wallet.GetSignerOpts()
+	assert.Nil(t, err)
+	tx, err := opts.Signer(opts.From, types.NewTx(data))
+	assert.Nil(t, err)
+	return tx
+}
+
+func TestTxManagerWait(t *testing.T) {
+	m, node, wallet := newTestTxManager(t)
+	m.BumpAfterBlocks = 1
+	var replaced []*types.Transaction
+	m.OnReplace = func(_, replacement *types.Transaction) { replaced = append(replaced, replacement) }
+	to := common.HexToAddress("0x1001")
+	tx := signTestTx(t, wallet, &types.LegacyTx{Nonce: 3, GasPrice: big.NewInt(100), Gas: 21000, To: &to, Value: big.NewInt(1)})
+
+	tracked, err := m.Send(context.Background(), tx)
+	assert.Nil(t, err)
+	if assert.Len(t, node.sent, 2, "a stuck transaction should be sped up") {
+		assert.Equal(t, tracked.Mined.Hash(), node.sent[1].Hash(), "the replacement mined should be attributed to the original")
+	}
+	assert.Equal(t, tx.Hash(), tracked.Original.Hash())
+	assert.Equal(t, "112", tracked.Mined.GasPrice().String())
+	assert.Equal(t, tx.Nonce(), tracked.Mined.Nonce())
+	assert.Equal(t, tx.Data(), tracked.Mined.Data())
+	assert.Len(t, replaced, 1)
+	assert.False(t, tracked.Cancelled())
+}
+
+func TestTxManagerSpeedUp(t *testing.T) {
+	to := common.HexToAddress("0x1001")
+	testCases := []struct {
+		name   string
+		tx     types.TxData
+		gasCap *big.Int
+		price  int64
+		tip    int64
+		err    bool
+	}{
+		{name: "legacy", tx: &types.LegacyTx{GasPrice: big.NewInt(100), Gas: 21000, To: &to}, price: 112},
+		{name: "legacy capped", tx: &types.LegacyTx{GasPrice: big.NewInt(100), Gas: 21000, To: &to}, gasCap: big.NewInt(111), price: 111},
+		{name: "legacy under cap", tx: &types.LegacyTx{GasPrice: big.NewInt(100), Gas: 21000, To: &to}, gasCap: big.NewInt(105), err: true},
+		{name: "dynamic", tx: &types.DynamicFeeTx{GasFeeCap: big.NewInt(200), GasTipCap: big.NewInt(50), Gas: 21000, To: &to}, price: 224, tip: 56},
+		{name: "dynamic tip at fee cap", tx: &types.DynamicFeeTx{GasFeeCap: big.NewInt(100), GasTipCap: big.NewInt(100), Gas: 21000, To: &to}, gasCap: big.NewInt(110), price: 110, tip: 110},
+		{name: "dynamic under cap", tx: &types.DynamicFeeTx{GasFeeCap: big.NewInt(200), GasTipCap: big.NewInt(50), Gas: 21000, To: &to}, gasCap: big.NewInt(210), err: true},
+	}
+
+	for _, tc := range testCases {
+		m, node, wallet := newTestTxManager(t)
+		m.GasCap = tc.gasCap
+		tracked := &TrackedTx{Original: signTestTx(t, wallet, tc.tx)}
+		tracked.add(tracked.Original)
+
+		err := m.SpeedUp(context.Background(), tracked)
+		if tc.err {
+			assert.Error(t, err, tc.name)
+			assert.Empty(t, node.sent, "%s: nothing should be sent over the gas cap", tc.name)
+			continue
+		}
+		assert.Nil(t, err, tc.name)
+		replacement := tracked.Latest()
+		if tc.tip == 0 {
+			assert.Equal(t, big.NewInt(tc.price), replacement.GasPrice(), tc.name)
+		} else {
+			assert.Equal(t, big.NewInt(tc.price), replacement.GasFeeCap(), tc.name)
+			assert.Equal(t, big.NewInt(tc.tip), replacement.GasTipCap(), tc.name)
+		}
+		assert.Len(t, node.sent, 1, tc.name)
+	}
+}
+
+func TestTxManagerCancel(t *testing.T) {
+	m, node, wallet := newTestTxManager(t)
+	to := common.HexToAddress("0x1001")
+	tx := signTestTx(t, wallet, &types.DynamicFeeTx{ChainID: big.NewInt(56), Nonce: 7, GasFeeCap: big.NewInt(200), GasTipCap: big.NewInt(50), Gas: 100000, To: &to, Value: big.NewInt(5), Data: []byte{1}})
+	tracked, err := m.Broadcast(context.Background(), tx)
+	assert.Nil(t, err)
+
+	assert.Nil(t, m.Cancel(context.Background(), tracked))
+	cancel := tracked.Latest()
+	assert.Equal(t, wallet.Address(), *cancel.To(), "a cancel is a transfer to the wallet itself")
+	assert.Equal(t, uint64(7), cancel.Nonce())
+	assert.Equal(t, "0", cancel.Value().String())
+	assert.Empty(t, cancel.Data())
+	assert.Equal(t, "224", cancel.GasFeeCap().String())
+	assert.Len(t, tracked.Hashes(), 2)
+
+	assert.Nil(t, m.Wait(context.Background(), tracked))
+	assert.True(t, tracked.Cancelled())
+	assert.Len(t, node.sent, 2)
+}
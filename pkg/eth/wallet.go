@@ -3,23 +3,52 @@ package eth
 import (
 	"context"
 	"crypto/ecdsa"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/big"
+	"os"
 
+	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/external"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"golang.org/x/term"
 )
 
+const DefaultPassphraseEnv = "SNIPER_PASSPHRASE"
+
+// Wallet signs either with a private key, taken raw or decrypted from a
+// keystore file, or through an external signer holding the key.
 type Wallet struct {
-	publicKey  *ecdsa.PublicKey
+	address    common.Address
 	privateKey *ecdsa.PrivateKey
+	signer     *external.ExternalSigner
 	chainID    *big.Int
+
+	Nonces *NonceManager
+}
+
+// WalletSource tells where the key of a wallet is. Exactly one of PrivateKey,
+// Keystore and SignerUrl is expected.
+type WalletSource struct {
+	PrivateKey string
+
+	Keystore string
+	// Passphrase is only asked for when the keystore is opened
+	Passphrase func() (string, error)
+
+	// SignerUrl points to a Clef compatible signer, signing for Address or
+	// for its first account when Address is not set
+	SignerUrl string
+	Address   common.Address
 }
 
 func (w *Wallet) Address() common.Address {
-	return crypto.PubkeyToAddress(*w.publicKey)
+	return w.address
 }
 
 func NewWallet(privKey string, chainID int64) (*Wallet, error) {
@@ -27,21 +56,110 @@ func NewWallet(privKey string, chainID int64) (*Wallet, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newKeyWallet(priv, chainID)
+}
 
+func newKeyWallet(priv *ecdsa.PrivateKey, chainID int64) (*Wallet, error) {
 	pub, ok := priv.Public().(*ecdsa.PublicKey)
 	if !ok {
 		return nil, fmt.Errorf("Failed to cast public key to ECDSA")
 	}
 
+	address := crypto.PubkeyToAddress(*pub)
 	w := &Wallet{
-		publicKey:  pub,
+		address:    address,
 		privateKey: priv,
 		chainID:    big.NewInt(chainID),
+		Nonces:     NewNonceManager(address),
 	}
 
 	return w, nil
 }
 
+// NewKeystoreWallet decrypts a geth keystore file with the passphrase.
+func NewKeystoreWallet(path, passphrase string, chainID int64) (*Wallet, error) {
+	keyJson, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read keystore file: %s", err)
+	}
+
+	key, err := keystore.DecryptKey(keyJson, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt keystore file: %s", err)
+	}
+	return newKeyWallet(key.PrivateKey, chainID)
+}
+
+// NewExternalWallet signs for the address through the signer at url, using
+// its first account when address is the zero address.
+func NewExternalWallet(url string, address common.Address, chainID int64) (*Wallet, error) {
+	signer, err := external.NewExternalSigner(url)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to external signer: %s", err)
+	}
+
+	if address == (common.Address{}) {
+		signerAccounts := signer.Accounts()
+		if len(signerAccounts) == 0 {
+			return nil, errors.New("External signer has no account")
+		}
+		address = signerAccounts[0].Address
+	}
+
+	w := &Wallet{
+		address: address,
+		signer:  signer,
+		chainID: big.NewInt(chainID),
+		Nonces:  NewNonceManager(address),
+	}
+
+	return w, nil
+}
+
+// PassphraseFrom reads the keystore passphrase from the environment variable,
+// prompting for it on the terminal when the variable is not set.
+func PassphraseFrom(envVar string) func() (string, error) {
+	return func() (string, error) {
+		passphrase, ok := os.LookupEnv(envVar)
+		if ok {
+			return passphrase, nil
+		}
+
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return "", fmt.Errorf("%s is not set and there is no terminal to prompt for it", envVar)
+		}
+		fmt.Fprint(os.Stderr, "Keystore passphrase: ")
+		input, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(input), err
+	}
+}
+
+// OpenWallet opens the wallet from whichever source was given.
+func OpenWallet(src WalletSource, chainID int64) (*Wallet, error) {
+	switch {
+	case src.SignerUrl != "":
+		return NewExternalWallet(src.SignerUrl, src.Address, chainID)
+	case src.Keystore != "":
+		passphrase, err := src.Passphrase()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get keystore passphrase: %s", err)
+		}
+		w, err := NewKeystoreWallet(src.Keystore, passphrase, chainID)
+		if err != nil {
+			return nil, err
+		}
+		if src.Address != (common.Address{}) && src.Address != w.Address() {
+			return nil, fmt.Errorf("Keystore holds the key of %s instead of %s", w.Address().Hex(), src.Address.Hex())
+		}
+		return w, nil
+	case src.PrivateKey != "":
+		return NewWallet(src.PrivateKey, chainID)
+	}
+	return nil, errors.New("No private key, keystore or external signer given")
+}
+
 func (w *Wallet) GetEthBalance(client *ethclient.Client, ctx context.Context, unit float64) (*big.Float, error) {
 	balanceWei, err := client.BalanceAt(ctx, w.Address(), nil)
 	if err != nil {
@@ -51,6 +169,11 @@ func (w *Wallet) GetEthBalance(client *ethclient.Client, ctx context.Context, un
 	return FromWei(balanceWei, unit), nil
 }
 
+// GetSignerOpts returns options signing with the key of the wallet, or
+// through its external signer which enforces its own chain ID.
 func (w *Wallet) GetSignerOpts() (*bind.TransactOpts, error) {
+	if w.signer != nil {
+		return bind.NewClefTransactor(w.signer, accounts.Account{Address: w.address}), nil
+	}
 	return bind.NewKeyedTransactorWithChainID(w.privateKey, w.chainID)
 }
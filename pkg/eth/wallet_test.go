@@ -0,0 +1,47 @@
+package eth
+
+import (
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenKeystoreWallet(t *testing.T) {
+	priv, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+	key := &keystore.Key{Id: uuid.New(), Address: crypto.PubkeyToAddress(priv.PublicKey), PrivateKey: priv}
+	keyJson, err := keystore.EncryptKey(key, "secret", keystore.LightScryptN, keystore.LightScryptP)
+	assert.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "key.json")
+	assert.Nil(t, ioutil.WriteFile(path, keyJson, 0600))
+
+	src := WalletSource{Keystore: path, Passphrase: func() (string, error) { return "secret", nil }}
+	w, err := OpenWallet(src, 56)
+	assert.Nil(t, err)
+	assert.Equal(t, key.Address, w.Address())
+
+	opts, err := w.GetSignerOpts()
+	assert.Nil(t, err)
+	tx, err := opts.Signer(opts.From, types.NewTransaction(0, common.Address{}, big.NewInt(0), 21000, big.NewInt(1), nil))
+	assert.Nil(t, err)
+	from, err := types.Sender(types.LatestSignerForChainID(big.NewInt(56)), tx)
+	assert.Nil(t, err)
+	assert.Equal(t, key.Address, from)
+
+	src.Passphrase = func() (string, error) { return "wrong", nil }
+	_, err = OpenWallet(src, 56)
+	assert.NotNil(t, err)
+
+	src.Passphrase = func() (string, error) { return "secret", nil }
+	src.Address = common.HexToAddress("0x1")
+	_, err = OpenWallet(src, 56)
+	assert.NotNil(t, err, "keystore of another address should be refused")
+}
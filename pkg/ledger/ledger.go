@@ -0,0 +1,125 @@
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type EventKind string
+
+const (
+	BuyEvent     EventKind = "buy"
+	ApproveEvent EventKind = "approve"
+	SellEvent    EventKind = "sell"
+	// ReplacementEvent records a transaction sent in place of another one
+	ReplacementEvent EventKind = "replacement"
+	// FeeEvent records gas spent by a transaction that moved no position,
+	// such as a reverted or cancelled one
+	FeeEvent EventKind = "fee"
+	// TransferEvent records tokens sent from a wallet to another one, moving
+	// the position along with them
+	TransferEvent EventKind = "transfer"
+)
+
+const DefaultPath = "ledger.jsonl"
+
+// Event is a single action of the sniper. AmountIn and AmountOut are the
+// amounts swapped, in the input token and the target token for buys and the
+// other way around for sells, and the amounts sent and received for
+// transfers. Cost is the input a swap spent, and the native coin sent by
+// other transactions.
+type Event struct {
+	Kind      EventKind      `json:"kind"`
+	Time      time.Time      `json:"time"`
+	Wallet    common.Address `json:"wallet"`
+	Token     common.Address `json:"token,omitempty"`
+	TxHash    common.Hash    `json:"txHash"`
+	Block     uint64         `json:"block,omitempty"`
+	AmountIn  *big.Int       `json:"amountIn,omitempty"`
+	AmountOut *big.Int       `json:"amountOut,omitempty"`
+	Cost      *big.Int       `json:"cost,omitempty"`
+	GasFees   *big.Int       `json:"gasFees,omitempty"`
+	// Replaced is the transaction a replacement was sent for, and To the
+	// wallet a transfer was sent to
+	Replaced *common.Hash    `json:"replaced,omitempty"`
+	To       *common.Address `json:"to,omitempty"`
+}
+
+// Ledger appends events to a JSON lines file, synced on every event so that
+// what was done survives a crash.
+type Ledger struct {
+	Path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func Open(path string) (*Ledger, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open ledger: %s", err)
+	}
+	return &Ledger{Path: path, file: file}, nil
+}
+
+func (l *Ledger) Record(ev *Event) error {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("Cannot encode %s event: %s", ev.Kind, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("Failed to write %s event: %s", ev.Kind, err)
+	}
+	return l.file.Sync()
+}
+
+func (l *Ledger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// ReadEvents reads every event of the ledger at path, none when it does not
+// exist yet. A line torn by a crash is skipped.
+func ReadEvents(path string) ([]*Event, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open ledger: %s", err)
+	}
+	defer file.Close()
+
+	var events []*Event
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		ev := new(Event)
+		err := json.Unmarshal(scanner.Bytes(), ev)
+		if err != nil {
+			log.Printf("Skipping line %d of ledger %s: %s", n, path, err)
+			continue
+		}
+		events = append(events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read ledger: %s", err)
+	}
+	return events, nil
+}
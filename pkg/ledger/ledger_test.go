@@ -0,0 +1,103 @@
+package ledger
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenPositions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	book, err := Open(path)
+	assert.Nil(t, err)
+
+	wallet, other := common.HexToAddress("0x1"), common.HexToAddress("0x2")
+	token, sold := common.HexToAddress("0xa"), common.HexToAddress("0xb")
+	replaced := common.HexToHash("0x10")
+	events := []*Event{
+		{Kind: BuyEvent, Wallet: wallet, Token: token, AmountIn: big.NewInt(100), AmountOut: big.NewInt(1000), GasFees: big.NewInt(3)},
+		{Kind: BuyEvent, Wallet: other, Token: sold, AmountIn: big.NewInt(50), AmountOut: big.NewInt(500), GasFees: big.NewInt(3)},
+		{Kind: ReplacementEvent, Wallet: wallet, TxHash: common.HexToHash("0x11"), Replaced: &replaced},
+		{Kind: ApproveEvent, Wallet: wallet, Token: token, AmountIn: big.NewInt(1000), GasFees: big.NewInt(2)},
+		{Kind: SellEvent, Wallet: wallet, Token: token, AmountIn: big.NewInt(400), AmountOut: big.NewInt(60), GasFees: big.NewInt(1)},
+		{Kind: SellEvent, Wallet: other, Token: sold, AmountIn: big.NewInt(500), AmountOut: big.NewInt(40), GasFees: big.NewInt(1)},
+		{Kind: FeeEvent, Wallet: other, Token: sold, GasFees: big.NewInt(1)},
+	}
+	for _, ev := range events {
+		assert.Nil(t, book.Record(ev))
+	}
+	assert.Nil(t, book.Close())
+
+	// a crash may leave the last line torn
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.Nil(t, err)
+	_, err = f.WriteString(`{"kind":"buy","wal`)
+	assert.Nil(t, err)
+	f.Close()
+
+	read, err := ReadEvents(path)
+	assert.Nil(t, err)
+	assert.Len(t, read, len(events))
+	assert.Equal(t, replaced, *read[2].Replaced)
+
+	positions := OpenPositions(read)
+	if assert.Len(t, positions, 1, "sold out positions should be closed") {
+		p := positions[0]
+		assert.Equal(t, wallet, p.Wallet)
+		assert.Equal(t, token, p.Token)
+		assert.Equal(t, "600", p.Amount.String())
+		assert.Equal(t, "60", p.Cost.String(), "cost should follow the tokens still held")
+		assert.Equal(t, "6", p.GasFees.String())
+	}
+
+	missing, err := ReadEvents(filepath.Join(t.TempDir(), "missing.jsonl"))
+	assert.Nil(t, err)
+	assert.Empty(t, missing)
+}
+
+func TestTaxedPositionCloses(t *testing.T) {
+	wallet, token := common.HexToAddress("0x1"), common.HexToAddress("0xa")
+	// the pairs swapped 1000 tokens each way, a 10% tax keeping 100 on the
+	// buy and the wallet sending the 900 it received on the sell
+	events := []*Event{
+		{Kind: BuyEvent, Wallet: wallet, Token: token, AmountIn: big.NewInt(100), AmountOut: big.NewInt(900)},
+		{Kind: SellEvent, Wallet: wallet, Token: token, AmountIn: big.NewInt(900), AmountOut: big.NewInt(80)},
+	}
+	assert.Empty(t, OpenPositions(events), "a position sold in full should close despite the tax")
+}
+
+func TestTransferMovesPosition(t *testing.T) {
+	pool, main := common.HexToAddress("0x1"), common.HexToAddress("0x2")
+	token := common.HexToAddress("0xa")
+	// the pool wallet failed to sell and sent 600 of its 1000 tokens to the
+	// main wallet, which received 540 after a 10% tax
+	events := []*Event{
+		{Kind: BuyEvent, Wallet: pool, Token: token, AmountIn: big.NewInt(100), AmountOut: big.NewInt(1000), GasFees: big.NewInt(10)},
+		{Kind: BuyEvent, Wallet: main, Token: token, AmountIn: big.NewInt(30), AmountOut: big.NewInt(300), GasFees: big.NewInt(5)},
+		{Kind: TransferEvent, Wallet: pool, Token: token, AmountIn: big.NewInt(600), AmountOut: big.NewInt(540), GasFees: big.NewInt(2), To: &main},
+		{Kind: TransferEvent, Wallet: pool, Token: token, AmountIn: big.NewInt(400), AmountOut: big.NewInt(360), GasFees: big.NewInt(2), To: &main},
+	}
+
+	positions := OpenPositions(events)
+	if assert.Len(t, positions, 1, "the pool wallet position should close once all its tokens are sent") {
+		p := positions[0]
+		assert.Equal(t, main, p.Wallet)
+		assert.Equal(t, "1200", p.Amount.String())
+		assert.Equal(t, "130", p.Cost.String(), "the cost should follow the tokens sent")
+		assert.Equal(t, "19", p.GasFees.String())
+	}
+
+	partial := OpenPositions(events[:3])
+	if assert.Len(t, partial, 2) {
+		assert.Equal(t, "400", partial[0].Amount.String())
+		assert.Equal(t, "40", partial[0].Cost.String())
+		assert.Equal(t, "4", partial[0].GasFees.String())
+		assert.Equal(t, "840", partial[1].Amount.String())
+		assert.Equal(t, "90", partial[1].Cost.String())
+		assert.Equal(t, "13", partial[1].GasFees.String())
+	}
+}
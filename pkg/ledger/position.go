@@ -0,0 +1,124 @@
+package ledger
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Position is what a wallet holds of a token it bought. Cost is the input
+// spent on what is still held, and GasFees the gas spent on the position so
+// far.
+type Position struct {
+	Wallet   common.Address
+	Token    common.Address
+	Amount   *big.Int
+	Cost     *big.Int
+	GasFees  *big.Int
+	OpenedAt time.Time
+}
+
+type positionKey struct {
+	wallet common.Address
+	token  common.Address
+}
+
+// OpenPositions replays the events, in the order they were recorded, into the
+// positions still held.
+func OpenPositions(events []*Event) []*Position {
+	positions := make(map[positionKey]*Position)
+	var order []positionKey
+	open := func(key positionKey, openedAt time.Time) *Position {
+		p := positions[key]
+		if p == nil {
+			p = &Position{
+				Wallet:   key.wallet,
+				Token:    key.token,
+				Amount:   big.NewInt(0),
+				Cost:     big.NewInt(0),
+				GasFees:  big.NewInt(0),
+				OpenedAt: openedAt,
+			}
+			positions[key] = p
+			order = append(order, key)
+		}
+		return p
+	}
+
+	for _, ev := range events {
+		key := positionKey{ev.Wallet, ev.Token}
+		p := positions[key]
+
+		switch ev.Kind {
+		case BuyEvent:
+			p = open(key, ev.Time)
+			addTo(p.Amount, ev.AmountOut)
+			addTo(p.Cost, ev.AmountIn)
+		case SellEvent:
+			if p == nil || ev.AmountIn == nil {
+				continue
+			}
+			// the cost left is that of the tokens still held
+			if p.Amount.Sign() > 0 {
+				sold := new(big.Int).Mul(p.Cost, ev.AmountIn)
+				p.Cost.Sub(p.Cost, sold.Div(sold, p.Amount))
+			}
+			p.Amount.Sub(p.Amount, ev.AmountIn)
+		case TransferEvent:
+			if p == nil || ev.AmountIn == nil || ev.To == nil {
+				continue
+			}
+			// the tokens sent carry their share of the cost and gas fees over
+			// to the position of the wallet receiving them
+			to := open(positionKey{*ev.To, ev.Token}, p.OpenedAt)
+			received := ev.AmountOut
+			if received == nil {
+				received = ev.AmountIn
+			}
+			to.Amount.Add(to.Amount, received)
+			if p.Amount.Sign() > 0 {
+				cost := new(big.Int).Mul(p.Cost, ev.AmountIn)
+				cost.Div(cost, p.Amount)
+				gasFees := new(big.Int).Mul(p.GasFees, ev.AmountIn)
+				gasFees.Div(gasFees, p.Amount)
+				p.Cost.Sub(p.Cost, cost)
+				p.GasFees.Sub(p.GasFees, gasFees)
+				to.Cost.Add(to.Cost, cost)
+				to.GasFees.Add(to.GasFees, gasFees)
+			}
+			p.Amount.Sub(p.Amount, ev.AmountIn)
+			if p.Amount.Sign() <= 0 {
+				delete(positions, key)
+			}
+			addTo(to.GasFees, ev.GasFees)
+			continue
+		case ApproveEvent, FeeEvent:
+		default:
+			continue
+		}
+
+		if p != nil {
+			addTo(p.GasFees, ev.GasFees)
+			if p.Amount.Sign() <= 0 {
+				delete(positions, key)
+			}
+		}
+	}
+
+	var held []*Position
+	for _, key := range order {
+		if p, ok := positions[key]; ok {
+			held = append(held, p)
+			// a position closed and opened again is listed once
+			delete(positions, key)
+		}
+	}
+	return held
+}
+
+func addTo(total, amount *big.Int) {
+	if amount != nil {
+		total.Add(total, amount)
+	}
+}
@@ -0,0 +1,47 @@
+package swap
+
+import (
+	"math/big"
+	"sync"
+)
+
+// Budget caps the total amount spent by buys running concurrently.
+type Budget struct {
+	mu        sync.Mutex
+	remaining *big.Int
+}
+
+func NewBudget(total *big.Int) *Budget {
+	return &Budget{remaining: new(big.Int).Set(total)}
+}
+
+// Reserve takes up to amount out of the budget and returns how much was
+// granted, which is less than amount when the budget runs short.
+func (b *Budget) Reserve(amount *big.Int) *big.Int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	granted := new(big.Int).Set(amount)
+	if granted.Cmp(b.remaining) > 0 {
+		granted.Set(b.remaining)
+	}
+	b.remaining.Sub(b.remaining, granted)
+	return granted
+}
+
+// Release gives back a reserved amount that was not spent.
+func (b *Budget) Release(amount *big.Int) {
+	if amount.Sign() <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remaining.Add(b.remaining, amount)
+}
+
+func (b *Budget) Remaining() *big.Int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return new(big.Int).Set(b.remaining)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
 
 	pancake "sniper/contracts/bsc/pancakeswap"
 	eth "sniper/pkg/eth"
@@ -17,10 +18,18 @@ type DexRouter interface {
 	SwapExactTokensForETH(opts *bind.TransactOpts, amountIn *big.Int, amountOutMin *big.Int, path []common.Address, to common.Address, deadline *big.Int) (*types.Transaction, error)
 	SwapExactETHForTokensSupportingFeeOnTransferTokens(opts *bind.TransactOpts, amountOutMin *big.Int, path []common.Address, to common.Address, deadline *big.Int) (*types.Transaction, error)
 	SwapExactTokensForETHSupportingFeeOnTransferTokens(opts *bind.TransactOpts, amountIn *big.Int, amountOutMin *big.Int, path []common.Address, to common.Address, deadline *big.Int) (*types.Transaction, error)
+	SwapExactTokensForTokens(opts *bind.TransactOpts, amountIn *big.Int, amountOutMin *big.Int, path []common.Address, to common.Address, deadline *big.Int) (*types.Transaction, error)
+	SwapExactTokensForTokensSupportingFeeOnTransferTokens(opts *bind.TransactOpts, amountIn *big.Int, amountOutMin *big.Int, path []common.Address, to common.Address, deadline *big.Int) (*types.Transaction, error)
+	SwapETHForExactTokens(opts *bind.TransactOpts, amountOut *big.Int, path []common.Address, to common.Address, deadline *big.Int) (*types.Transaction, error)
+	SwapTokensForExactETH(opts *bind.TransactOpts, amountOut *big.Int, amountInMax *big.Int, path []common.Address, to common.Address, deadline *big.Int) (*types.Transaction, error)
+	SwapTokensForExactTokens(opts *bind.TransactOpts, amountOut *big.Int, amountInMax *big.Int, path []common.Address, to common.Address, deadline *big.Int) (*types.Transaction, error)
+	GetAmountsOut(opts *bind.CallOpts, amountIn *big.Int, path []common.Address) ([]*big.Int, error)
+	GetAmountsIn(opts *bind.CallOpts, amountOut *big.Int, path []common.Address) ([]*big.Int, error)
 }
 
 type DexFactory interface {
 	GetPair(opts *bind.CallOpts, arg0 common.Address, arg1 common.Address) (common.Address, error)
+	WatchPairCreated(opts *bind.WatchOpts, sink chan<- *pancake.PancakeFactoryPairCreated, token0 []common.Address, token1 []common.Address) (event.Subscription, error)
 }
 
 type DexPair interface {
@@ -38,8 +47,11 @@ type Dex struct {
 	FactoryContract *eth.Contract
 	Router          DexRouter
 	RouterContract  *eth.Contract
+	FeeBps          int64
 }
 
+const DefaultFeeBps = 25
+
 func SetupDex(client bind.ContractBackend, factoryAddress, routerAddress common.Address) (*Dex, error) {
 	factoryContract, err := eth.NewContract(factoryAddress, pancake.PancakeFactoryMetaData)
 	if err != nil {
@@ -64,6 +76,20 @@ func SetupDex(client bind.ContractBackend, factoryAddress, routerAddress common.
 		FactoryContract: factoryContract,
 		Router:          Router,
 		RouterContract:  routerContract,
+		FeeBps:          DefaultFeeBps,
 	}
 	return d, nil
 }
+
+// FeeFromAmountIn is the liquidity provider fee charged on a swap input.
+func (d *Dex) FeeFromAmountIn(amountIn *big.Int) *big.Int {
+	fee := new(big.Int).Mul(amountIn, big.NewInt(d.FeeBps))
+	return fee.Div(fee, big.NewInt(10000))
+}
+
+// FeeFromAmountOut is the liquidity provider fee charged on a swap input,
+// expressed in the output token.
+func (d *Dex) FeeFromAmountOut(amountOut *big.Int) *big.Int {
+	fee := new(big.Int).Mul(amountOut, big.NewInt(d.FeeBps))
+	return fee.Div(fee, big.NewInt(10000-d.FeeBps))
+}
@@ -0,0 +1,83 @@
+package swap
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// executorCode is placed at the wallet through a state override to run a
+// sequence of calls from it in a single eth_call, so that each call sees the
+// state left by the ones before it. The call data is a sequence of records
+// made of a 32 byte address, a 32 byte value and a 32 byte length, followed
+// by that many bytes of call data. For every call that succeeds it returns the
+// balance of the wallet after the call, the length of the return data and the
+// return data. The first call that reverts ends the sequence.
+//
+//	      PUSH1 0 PUSH1 0                               ; outLen ptr
+//	loop: JUMPDEST
+//	      CALLDATASIZE DUP2 LT ISZERO PUSH2 end JUMPI
+//	      DUP1 CALLDATALOAD                             ; to
+//	      DUP2 PUSH1 0x20 ADD CALLDATALOAD              ; value
+//	      DUP3 PUSH1 0x40 ADD CALLDATALOAD              ; len
+//	      DUP1 DUP5 PUSH1 0x60 ADD PUSH1 0 CALLDATACOPY
+//	      PUSH1 0 PUSH1 0 DUP3 PUSH1 0 DUP6 DUP8 GAS CALL
+//	      SWAP2 POP SWAP2 POP SWAP1                     ; outLen ptr success len
+//	      PUSH1 0x60 ADD DUP3 ADD SWAP2 POP             ; outLen next success
+//	      ISZERO PUSH2 end JUMPI
+//	      SELFBALANCE DUP3 PUSH3 0x010000 ADD MSTORE
+//	      RETURNDATASIZE DUP3 PUSH3 0x010020 ADD MSTORE
+//	      RETURNDATASIZE PUSH1 0 DUP4 PUSH3 0x010040 ADD RETURNDATACOPY
+//	      SWAP1 RETURNDATASIZE ADD PUSH1 0x40 ADD SWAP1
+//	      PUSH2 loop JUMP
+//	end:  JUMPDEST POP PUSH3 0x010000 RETURN
+var executorCode = common.FromHex("0x600060005b368110156100625780358160200135826040013580846060016000376000600082600085875af1915091509060600182019150156100625747826201000001523d826201002001523d60008362010040013e903d0160400190610004565b5062010000f3")
+
+type executorCall struct {
+	To    common.Address
+	Value *big.Int
+	Data  []byte
+}
+
+type executorResult struct {
+	Balance *big.Int
+	Return  []byte
+}
+
+func packExecutorCalls(calls []executorCall) []byte {
+	var input []byte
+	for _, call := range calls {
+		value := call.Value
+		if value == nil {
+			value = new(big.Int)
+		}
+		input = append(input, common.LeftPadBytes(call.To.Bytes(), 32)...)
+		input = append(input, common.LeftPadBytes(value.Bytes(), 32)...)
+		input = append(input, common.LeftPadBytes(big.NewInt(int64(len(call.Data))).Bytes(), 32)...)
+		input = append(input, call.Data...)
+	}
+	return input
+}
+
+// unpackExecutorResults returns the results of the calls that succeeded, in
+// the order they were made.
+func unpackExecutorResults(out []byte) ([]executorResult, error) {
+	var results []executorResult
+	for len(out) > 0 {
+		if len(out) < 64 {
+			return nil, errors.New("executor output is truncated")
+		}
+		size := new(big.Int).SetBytes(out[32:64])
+		if !size.IsInt64() || size.Int64() > int64(len(out)-64) {
+			return nil, errors.New("executor output is truncated")
+		}
+		end := 64 + int(size.Int64())
+		results = append(results, executorResult{
+			Balance: new(big.Int).SetBytes(out[:32]),
+			Return:  out[64:end],
+		})
+		out = out[end:]
+	}
+	return results, nil
+}
@@ -0,0 +1,159 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+
+	eth "sniper/pkg/eth"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// LimitToTokenLimits keeps the buy under the max transaction and max wallet
+// limits of TokenOut, which revert buys above them. The limits the token
+// exposes are applied first, then the largest AmountIn that does not revert
+// is binary searched through eth_call on the pending block, catching limits
+// the token hides. Exact-output swaps only get AmountOut capped.
+func (s *DexSwap) LimitToTokenLimits(client *ethclient.Client, ctx context.Context, router DexRouter) error {
+	limits, err := s.TokenOut.GetLimits(client, ctx)
+	if err != nil {
+		return err
+	}
+	balance, err := s.TokenOut.BalanceOf(&bind.CallOpts{Pending: true, Context: ctx}, s.FromWallet.Address())
+	if err != nil {
+		return fmt.Errorf("Failed to get %s balance: %s", s.TokenOut.Symbol, err)
+	}
+
+	maxOut := limits.MaxBuy(balance)
+	if maxOut != nil {
+		if maxOut.Sign() == 0 {
+			return fmt.Errorf("wallet already holds the most %s allows", s.TokenOut.Symbol)
+		}
+		log.Printf("%s limits buys to %.18f %s", s.TokenOut.Symbol, eth.FromWei(maxOut, params.Ether), s.TokenOut.Symbol)
+	}
+
+	if s.AmountOut != nil {
+		if maxOut != nil && s.AmountOut.Cmp(maxOut) > 0 {
+			s.AmountOut = maxOut
+		}
+		return nil
+	}
+
+	max := s.AmountIn
+	if maxOut != nil {
+		amounts, err := router.GetAmountsIn(&bind.CallOpts{Pending: true, Context: ctx}, maxOut, s.Path())
+		if err != nil {
+			return fmt.Errorf("Failed to quote max buy: %s", err)
+		}
+		if amounts[0].Cmp(max) < 0 {
+			max = amounts[0]
+		}
+	}
+
+	passes := func(amountIn *big.Int) (bool, error) {
+		// nothing bought passes any limit
+		if amountIn.Sign() == 0 {
+			return true, nil
+		}
+		return s.BuyPasses(client, ctx, router, amountIn)
+	}
+	ok, err := passes(max)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		max, err = searchMaxPassing(max, passes)
+		if err != nil {
+			return err
+		}
+		if max.Sign() == 0 {
+			return errors.New("buy reverts at any amount")
+		}
+	}
+
+	if max.Cmp(s.AmountIn) < 0 {
+		log.Printf(
+			"Scaling buy of %s down from %.18f to %.18f %s to stay within its limits",
+			s.TokenOut.Symbol, eth.FromWei(s.AmountIn, params.Ether), eth.FromWei(max, params.Ether), s.TokenIn.Symbol,
+		)
+		s.ScaleAmountIn(max)
+	}
+	return nil
+}
+
+// BuyPasses tells whether the swap of amountIn executes without reverting,
+// with no minimum output so that only the token itself can revert it.
+func (s *DexSwap) BuyPasses(client *ethclient.Client, ctx context.Context, router DexRouter, amountIn *big.Int) (bool, error) {
+	probe := *s
+	probe.AmountIn = amountIn
+	probe.AmountOutMin = big.NewInt(0)
+	tx, err := probe.buildCall(router, ctx)
+	if err != nil {
+		return false, err
+	}
+
+	msg := ethereum.CallMsg{From: s.FromWallet.Address(), To: tx.To(), Value: tx.Value(), Data: tx.Data()}
+	_, err = client.PendingCallContract(ctx, msg)
+	if eth.IsRevert(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Failed to simulate buy: %s", err)
+	}
+	return true, nil
+}
+
+// buildCall builds the swap transaction only for its call data, it is neither
+// signed nor sent.
+func (s *DexSwap) buildCall(router DexRouter, ctx context.Context) (*types.Transaction, error) {
+	opts := &bind.TransactOpts{
+		From:     s.FromWallet.Address(),
+		Nonce:    big.NewInt(0),
+		GasPrice: big.NewInt(0),
+		GasLimit: 1,
+		Context:  ctx,
+		NoSend:   true,
+		Signer: func(_ common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			return tx, nil
+		},
+	}
+	tx, err := s.SwapFunc(router, s, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build swap call: %s", err)
+	}
+	return tx, nil
+}
+
+// searchMaxPassing binary searches the largest amount up to max, within 1 bps
+// of it, for which passes holds. It returns nil when nothing passes.
+func searchMaxPassing(max *big.Int, passes func(amount *big.Int) (bool, error)) (*big.Int, error) {
+	ok, err := passes(big.NewInt(0))
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	lo, hi := big.NewInt(0), new(big.Int).Set(max)
+	tolerance := new(big.Int).Div(max, big.NewInt(10000))
+	for new(big.Int).Sub(hi, lo).Cmp(tolerance) > 0 {
+		mid := new(big.Int).Add(lo, hi)
+		mid.Rsh(mid, 1)
+		ok, err := passes(mid)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
@@ -0,0 +1,173 @@
+package swap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	pancake "sniper/contracts/bsc/pancakeswap"
+	eth "sniper/pkg/eth"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// GetReserves returns the pair reserves ordered as tokenIn, tokenOut. Pairs
+// that were not created yet have no reserves.
+func (d *Dex) GetReserves(client *ethclient.Client, ctx context.Context, tokenIn, tokenOut common.Address) (reserveIn, reserveOut *big.Int, err error) {
+	opts := &bind.CallOpts{
+		Pending:     true,
+		BlockNumber: nil,
+		Context:     ctx,
+	}
+
+	pairAddr, err := d.Factory.GetPair(opts, tokenIn, tokenOut)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to get pair address: %s", err)
+	}
+	if pairAddr == (common.Address{}) {
+		return big.NewInt(0), big.NewInt(0), nil
+	}
+
+	pair, err := pancake.NewPancakePair(pairAddr, client)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to instantiate pair client: %s", err)
+	}
+	reserves, err := pair.GetReserves(opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to get pair reserves: %s", err)
+	}
+
+	// pairs sort their tokens by address
+	if bytes.Compare(tokenIn.Bytes(), tokenOut.Bytes()) < 0 {
+		return reserves.Reserve0, reserves.Reserve1, nil
+	}
+	return reserves.Reserve1, reserves.Reserve0, nil
+}
+
+// GetPendingLiquidity decodes the amounts a pending addLiquidity or
+// addLiquidityETH transaction adds to the tokenIn/tokenOut pair. The native
+// coin sent to addLiquidityETH is counted as tokenIn.
+func (d *Dex) GetPendingLiquidity(tx *types.Transaction, tokenIn, tokenOut common.Address) (amountIn, amountOut *big.Int, err error) {
+	method, args, err := eth.GetTxCallData(d.RouterContract.ABI, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch method.Name {
+	case "addLiquidityETH":
+		if args["token"].(common.Address) != tokenOut {
+			return nil, nil, errors.New("liquidity is not added to the target token")
+		}
+		return tx.Value(), args["amountTokenDesired"].(*big.Int), nil
+	case "addLiquidity":
+		tokenA, tokenB := args["tokenA"].(common.Address), args["tokenB"].(common.Address)
+		amountA, amountB := args["amountADesired"].(*big.Int), args["amountBDesired"].(*big.Int)
+		if tokenA == tokenIn && tokenB == tokenOut {
+			return amountA, amountB, nil
+		}
+		if tokenA == tokenOut && tokenB == tokenIn {
+			return amountB, amountA, nil
+		}
+		return nil, nil, errors.New("liquidity is not added to the target pair")
+	}
+	return nil, nil, fmt.Errorf("method %s does not add liquidity", method.Name)
+}
+
+// GetExpectedReserves adds the amounts of a pending liquidity transaction, if
+// any, to the current pair reserves.
+func (d *Dex) GetExpectedReserves(client *ethclient.Client, ctx context.Context, tokenIn, tokenOut common.Address, pendingTx *types.Transaction) (reserveIn, reserveOut *big.Int, err error) {
+	reserveIn, reserveOut, err = d.GetReserves(client, ctx, tokenIn, tokenOut)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a transaction to another contract, such as the token enabling trading,
+	// adds no liquidity
+	if pendingTx != nil && pendingTx.To() != nil && *pendingTx.To() == d.RouterContract.Address {
+		addedIn, addedOut, err := d.GetPendingLiquidity(pendingTx, tokenIn, tokenOut)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to decode pending liquidity: %s", err)
+		}
+		reserveIn = new(big.Int).Add(reserveIn, addedIn)
+		reserveOut = new(big.Int).Add(reserveOut, addedOut)
+	}
+
+	if reserveIn.Sign() == 0 || reserveOut.Sign() == 0 {
+		return nil, nil, errors.New("pair has no liquidity")
+	}
+	return reserveIn, reserveOut, nil
+}
+
+// QuotePendingPath quotes amountIn along path from the pair reserves, with the
+// liquidity pendingTx adds to the last pair, which the router cannot quote
+// before the transaction is mined.
+func (d *Dex) QuotePendingPath(client *ethclient.Client, ctx context.Context, amountIn *big.Int, path []common.Address, pendingTx *types.Transaction) (*big.Int, error) {
+	amount := amountIn
+	for i := 0; i+1 < len(path); i++ {
+		var tx *types.Transaction
+		if i+2 == len(path) {
+			tx = pendingTx
+		}
+		reserveIn, reserveOut, err := d.GetExpectedReserves(client, ctx, path[i], path[i+1], tx)
+		if err != nil {
+			return nil, err
+		}
+		amount = d.GetAmountOut(amount, reserveIn, reserveOut)
+	}
+	return amount, nil
+}
+
+// GetAmountOut mirrors the constant product formula of the pair contract.
+func (d *Dex) GetAmountOut(amountIn, reserveIn, reserveOut *big.Int) *big.Int {
+	amountInWithFee := new(big.Int).Mul(amountIn, big.NewInt(10000-d.FeeBps))
+	numerator := new(big.Int).Mul(amountInWithFee, reserveOut)
+	denominator := new(big.Int).Mul(reserveIn, big.NewInt(10000))
+	denominator.Add(denominator, amountInWithFee)
+	return numerator.Div(numerator, denominator)
+}
+
+// GetAmountIn mirrors the router quote of the input buying exactly amountOut.
+func (d *Dex) GetAmountIn(amountOut, reserveIn, reserveOut *big.Int) (*big.Int, error) {
+	if amountOut.Cmp(reserveOut) >= 0 {
+		return nil, errors.New("pair has not enough liquidity for the output")
+	}
+	numerator := new(big.Int).Mul(reserveIn, amountOut)
+	numerator.Mul(numerator, big.NewInt(10000))
+	denominator := new(big.Int).Sub(reserveOut, amountOut)
+	denominator.Mul(denominator, big.NewInt(10000-d.FeeBps))
+	amountIn := numerator.Div(numerator, denominator)
+	return amountIn.Add(amountIn, big.NewInt(1)), nil
+}
+
+// GetExecutionPrice is the average price paid in tokenIn per tokenOut when
+// swapping amountIn against the given reserves.
+func (d *Dex) GetExecutionPrice(amountIn, reserveIn, reserveOut *big.Int) (*big.Float, error) {
+	amountOut := d.GetAmountOut(amountIn, reserveIn, reserveOut)
+	if amountOut.Sign() == 0 {
+		return nil, errors.New("swap output is zero")
+	}
+	return eth.TokenRatio(amountIn, amountOut)
+}
+
+// GetMaxAmountIn is the largest input whose execution price stays at or below
+// maxPrice. The execution price grows linearly with the input:
+// (reserveIn*10000 + amountIn*(10000-fee)) / ((10000-fee)*reserveOut)
+func (d *Dex) GetMaxAmountIn(maxPrice *big.Float, reserveIn, reserveOut *big.Int) *big.Int {
+	feeFactor := new(big.Float).SetInt64(10000 - d.FeeBps)
+
+	limit := new(big.Float).SetPrec(256).Mul(maxPrice, feeFactor)
+	limit.Mul(limit, new(big.Float).SetInt(reserveOut))
+	limit.Sub(limit, new(big.Float).SetInt(new(big.Int).Mul(reserveIn, big.NewInt(10000))))
+	limit.Quo(limit, feeFactor)
+
+	if limit.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	maxAmountIn, _ := limit.Int(nil)
+	return maxAmountIn
+}
@@ -0,0 +1,127 @@
+package swap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+)
+
+// Storage slots of the pair contract, laid out as in Uniswap V2 which the
+// supported DEXes fork.
+var (
+	pairToken0Slot   = common.BigToHash(big.NewInt(6))
+	pairToken1Slot   = common.BigToHash(big.NewInt(7))
+	pairReservesSlot = common.BigToHash(big.NewInt(8))
+	pairUnlockedSlot = common.BigToHash(big.NewInt(12))
+)
+
+// liquidityOverrides returns the reserves of the pair once pendingTx is mined,
+// along with the state overrides putting them into the pair and its token
+// balances. A pair the transaction creates is overridden into existence at
+// the address the router derives for it.
+func (s *TradeSimulator) liquidityOverrides(ctx context.Context, pendingTx *types.Transaction) (*big.Int, *big.Int, map[common.Address]gethclient.OverrideAccount, error) {
+	tokenIn, tokenOut := s.swap.TokenIn, s.swap.TokenOut
+	reserveIn, reserveOut, err := s.dex.GetExpectedReserves(s.client, ctx, tokenIn.Address, tokenOut.Address, pendingTx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	overrides := make(map[common.Address]gethclient.OverrideAccount)
+	if pendingTx == nil || pendingTx.To() == nil || *pendingTx.To() != s.dex.RouterContract.Address {
+		return reserveIn, reserveOut, overrides, nil
+	}
+
+	token0, token1 := tokenIn.Address, tokenOut.Address
+	reserve0, reserve1 := reserveIn, reserveOut
+	if bytes.Compare(token1.Bytes(), token0.Bytes()) < 0 {
+		token0, token1 = token1, token0
+		reserve0, reserve1 = reserve1, reserve0
+	}
+	packed := new(big.Int).Lsh(reserve1, 112)
+	packed.Or(packed, reserve0)
+	pairState := map[common.Hash]common.Hash{pairReservesSlot: common.BigToHash(packed)}
+
+	pair, err := s.dex.Factory.GetPair(&bind.CallOpts{Pending: true, Context: ctx}, token0, token1)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Failed to get pair address: %s", err)
+	}
+	var code []byte
+	if pair == (common.Address{}) {
+		pair, code, err = s.pendingPair(ctx, token0, token1)
+		pairState[pairToken0Slot] = token0.Hash()
+		pairState[pairToken1Slot] = token1.Hash()
+		pairState[pairUnlockedSlot] = common.BigToHash(big.NewInt(1))
+	} else {
+		code, err = s.client.PendingCodeAt(ctx, pair)
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Failed to get pair code: %s", err)
+	}
+	overrides[pair] = gethclient.OverrideAccount{Code: code, StateDiff: pairState}
+
+	overrides[tokenIn.Address], err = tokenIn.StateOverride(s.client, ctx, map[common.Hash]common.Hash{
+		s.baseSlots.BalanceKey(pair): common.BigToHash(reserveIn),
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	overrides[tokenOut.Address], err = tokenOut.StateOverride(s.client, ctx, map[common.Hash]common.Hash{
+		s.slots.BalanceKey(pair): common.BigToHash(reserveOut),
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return reserveIn, reserveOut, overrides, nil
+}
+
+// pendingPair derives the address of a pair not created yet from the init
+// code hash of the factory, and borrows the code of the first pair the
+// factory created.
+func (s *TradeSimulator) pendingPair(ctx context.Context, token0, token1 common.Address) (common.Address, []byte, error) {
+	hash, err := s.callFactory(ctx, "INIT_CODE_PAIR_HASH")
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	first, err := s.callFactory(ctx, "allPairs", big.NewInt(0))
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	initCodeHash, ok := hash.([32]byte)
+	firstPair, ok2 := first.(common.Address)
+	if !ok || !ok2 {
+		return common.Address{}, nil, errors.New("unexpected factory output")
+	}
+
+	code, err := s.client.PendingCodeAt(ctx, firstPair)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	salt := crypto.Keccak256Hash(token0.Bytes(), token1.Bytes())
+	return crypto.CreateAddress2(s.dex.FactoryContract.Address, salt, initCodeHash[:]), code, nil
+}
+
+func (s *TradeSimulator) callFactory(ctx context.Context, method string, args ...interface{}) (interface{}, error) {
+	factory := s.dex.FactoryContract
+	data, err := factory.ABI.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.client.PendingCallContract(ctx, ethereum.CallMsg{To: &factory.Address, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to call %s of factory: %s", method, err)
+	}
+	values, err := factory.ABI.Unpack(method, out)
+	if err != nil || len(values) == 0 {
+		return nil, fmt.Errorf("Failed to decode %s of factory: %v", method, err)
+	}
+	return values[0], nil
+}
@@ -0,0 +1,172 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const DefaultRefreshInterval = 3 * time.Second
+
+// ArmedSwap keeps a swap built and signed ahead of time, so that firing it
+// only takes sending the raw transaction. It is signed at the next nonce of
+// the wallet without reserving it, so that other transactions are not held
+// back, and signed again whenever that nonce or the gas fees change, or its
+// deadline gets close.
+type ArmedSwap struct {
+	Swap            *DexSwap
+	RefreshInterval time.Duration
+
+	client *ethclient.Client
+	router DexRouter
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	tx       *types.Transaction
+	amountIn *big.Int
+	path     []common.Address
+	signedAt time.Time
+}
+
+// ArmSwap signs the swap and keeps it fresh until Disarm is called. Without a
+// GasLimit the gas is estimated once, which requires the swap to succeed
+// against the current pending state.
+func ArmSwap(client *ethclient.Client, router DexRouter, sw *DexSwap) (*ArmedSwap, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &ArmedSwap{
+		Swap:            sw,
+		RefreshInterval: DefaultRefreshInterval,
+		client:          client,
+		router:          router,
+		cancel:          cancel,
+		done:            make(chan struct{}),
+	}
+
+	err := a.refresh(ctx)
+	if err != nil {
+		close(a.done)
+		return nil, fmt.Errorf("Failed to pre-sign swap: %s", err)
+	}
+	log.Printf("Pre-signed swap of %s at nonce %d", sw.TokenOut.Symbol, a.tx.Nonce())
+
+	go a.keepFresh(ctx)
+	return a, nil
+}
+
+// Tx returns the signed transaction, or nil when the swap amount or path
+// changed since it was signed, its deadline is too close or its nonce was handed out in the
+// meantime. Once returned, its nonce is reserved for whoever sends it.
+func (a *ArmedSwap) Tx(amountIn *big.Int) *types.Transaction {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.tx == nil || a.amountIn.Cmp(amountIn) != 0 || a.expiring() || !samePath(a.path, a.Swap.Path()) {
+		return nil
+	}
+	if !a.Swap.FromWallet.Nonces.Claim(a.tx.Nonce()) {
+		return nil
+	}
+	return a.tx
+}
+
+// Disarm stops refreshing the swap, after which it can be changed again.
+func (a *ArmedSwap) Disarm() {
+	a.cancel()
+	<-a.done
+}
+
+func (a *ArmedSwap) keepFresh(ctx context.Context) {
+	defer close(a.done)
+	ticker := time.NewTicker(a.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := a.refresh(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("Failed to refresh pre-signed swap of %s: %s", a.Swap.TokenOut.Symbol, err)
+			}
+		}
+	}
+}
+
+func (a *ArmedSwap) refresh(ctx context.Context) error {
+	nonce, err := a.Swap.FromWallet.Nonces.Peek(a.client, ctx)
+	if err != nil {
+		return err
+	}
+
+	opts, err := a.Swap.buildTxOpts(a.client, ctx)
+	if err != nil {
+		return err
+	}
+	opts.Nonce = new(big.Int).SetUint64(nonce)
+
+	a.mu.Lock()
+	current, amountIn := a.tx, a.amountIn
+	fresh := current != nil && !a.expiring() &&
+		amountIn.Cmp(a.Swap.AmountIn) == 0 && samePath(a.path, a.Swap.Path()) &&
+		current.Nonce() == nonce &&
+		sameFees(current, opts.GasPrice, opts.GasFeeCap, opts.GasTipCap)
+	a.mu.Unlock()
+	if fresh {
+		return nil
+	}
+
+	// the gas estimated on the first signing holds for the next ones
+	if current != nil && opts.GasLimit == 0 {
+		opts.GasLimit = current.Gas()
+	}
+	amountIn = new(big.Int).Set(a.Swap.AmountIn)
+	path := a.Swap.Path()
+	tx, err := a.Swap.SwapFunc(a.router, a.Swap, opts)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tx = tx
+	a.amountIn = amountIn
+	a.path = path
+	a.signedAt = time.Now()
+	return nil
+}
+
+// expiring tells whether half of the swap expiration went by since signing.
+func (a *ArmedSwap) expiring() bool {
+	expiration := time.Duration(a.Swap.Expiration.Int64()) * time.Second
+	return time.Since(a.signedAt) > expiration/2
+}
+
+func samePath(a, b []common.Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameFees(tx *types.Transaction, gasPrice, gasFeeCap, gasTipCap *big.Int) bool {
+	if gasFeeCap != nil {
+		return tx.Type() == types.DynamicFeeTxType &&
+			tx.GasFeeCap().Cmp(gasFeeCap) == 0 && tx.GasTipCap().Cmp(gasTipCap) == 0
+	}
+	return tx.Type() == types.LegacyTxType && tx.GasPrice().Cmp(gasPrice) == 0
+}
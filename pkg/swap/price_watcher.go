@@ -6,28 +6,42 @@ import (
 	"fmt"
 	"log"
 	"math/big"
+	"sync"
+	"time"
 
 	pancake "sniper/contracts/bsc/pancakeswap"
 	eth "sniper/pkg/eth"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
 )
 
+// PriceWatcher follows the price of tokenB denominated in tokenA, taken from
+// the reserves of their pair, or of every pair along a route between them.
 type PriceWatcher struct {
 	tokenA       *eth.Token
 	tokenB       *eth.Token
+	mu           sync.RWMutex
 	currentPrice *big.Float
+	subscribers  []chan *big.Float
 }
 
-func NewPriceWatcher(client *ethclient.Client, dex *Dex, ctx context.Context, tokenA, tokenB *eth.Token, pending bool) (*PriceWatcher, error) {
+func NewPriceWatcher(client bind.ContractCaller, dex *Dex, ctx context.Context, tokenA, tokenB *eth.Token, pending bool) (*PriceWatcher, error) {
+	return NewRoutePriceWatcher(client, dex, ctx, tokenA, tokenB, nil, pending)
+}
+
+// NewRoutePriceWatcher follows the price of tokenB going from tokenA through
+// the via tokens, for tokens without a direct pair. The reserves are read
+// through client, which may pick a new node for every read.
+func NewRoutePriceWatcher(client bind.ContractCaller, dex *Dex, ctx context.Context, tokenA, tokenB *eth.Token, via []common.Address, pending bool) (*PriceWatcher, error) {
 	var err error
 	p := &PriceWatcher{
 		tokenA: tokenA,
 		tokenB: tokenB,
 	}
-	err = p.subscribe(client, dex, ctx, tokenA, tokenB, pending)
+	path := append([]common.Address{tokenA.Address}, via...)
+	path = append(path, tokenB.Address)
+	err = p.subscribe(client, dex, ctx, path, pending)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot subscribe to token prices: %s\n", err)
 	}
@@ -36,6 +50,8 @@ func NewPriceWatcher(client *ethclient.Client, dex *Dex, ctx context.Context, to
 }
 
 func (p *PriceWatcher) CurrentPrice() *big.Float {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return p.currentPrice
 }
 
@@ -43,30 +59,83 @@ func (p *PriceWatcher) Tokens() []common.Address {
 	return []common.Address{p.tokenA.Address, p.tokenB.Address}
 }
 
-func (p *PriceWatcher) subscribe(client *ethclient.Client, dex *Dex, ctx context.Context, tokenA *eth.Token, tokenB *eth.Token, pending bool) error {
-	var err error
+// Prices returns a stream of price updates, sent every time the pair price
+// changes. Updates are dropped while the receiver is busy, and the channel is
+// closed once the watcher stops.
+func (p *PriceWatcher) Prices() <-chan *big.Float {
+	ch := make(chan *big.Float, 1)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subscribers = append(p.subscribers, ch)
+
+	return ch
+}
+
+func (p *PriceWatcher) setPrice(price *big.Float) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.currentPrice != nil && p.currentPrice.Cmp(price) == 0 {
+		return
+	}
+	p.currentPrice = price
+
+	for _, ch := range p.subscribers {
+		select {
+		case ch <- price:
+		default:
+		}
+	}
+}
+
+func (p *PriceWatcher) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, ch := range p.subscribers {
+		close(ch)
+	}
+	p.subscribers = nil
+}
+
+type pricedPair struct {
+	pair      DexPair
+	sameOrder bool
+}
+
+func (p *PriceWatcher) subscribe(client bind.ContractCaller, dex *Dex, ctx context.Context, path []common.Address, pending bool) error {
 	opts := &bind.CallOpts{
 		Pending:     false,
 		BlockNumber: nil,
 		Context:     ctx,
 	}
 
-	pairAddr, err := dex.Factory.GetPair(opts, tokenA.Address, tokenB.Address)
-	if err != nil {
-		return err
-	}
+	var pairs []pricedPair
+	for i := 0; i < len(path)-1; i++ {
+		pairAddr, err := dex.Factory.GetPair(opts, path[i], path[i+1])
+		if err != nil {
+			return err
+		}
+		if pairAddr == (common.Address{}) {
+			return fmt.Errorf("no pair of %s and %s", path[i].Hex(), path[i+1].Hex())
+		}
 
-	pair, err := pancake.NewPancakePair(pairAddr, client)
-	if err != nil {
-		return err
-	}
+		pair, err := pancake.NewPancakePairCaller(pairAddr, client)
+		if err != nil {
+			return err
+		}
 
-	sameOrder, err := isAtSameOrderAsPair(ctx, pair, tokenA, tokenB)
-	if err != nil {
-		return fmt.Errorf("cannot determine token order of pair: %s", err)
+		sameOrder, err := isAtSameOrderAsPair(ctx, pair, path[i], path[i+1])
+		if err != nil {
+			return fmt.Errorf("cannot determine token order of pair: %s", err)
+		}
+		pairs = append(pairs, pricedPair{pair, sameOrder})
 	}
 
 	go func() {
+		defer p.stop()
+
 		opts := &bind.CallOpts{
 			Pending:     pending,
 			BlockNumber: nil,
@@ -77,30 +146,48 @@ func (p *PriceWatcher) subscribe(client *ethclient.Client, dex *Dex, ctx context
 			case <-ctx.Done():
 				return
 			default:
-				reserves, err := pair.GetReserves(opts)
-				if err != nil {
-					log.Printf("Error calling pair.GetReserves: %s\n", err)
-					return
-				}
-
-				var price *big.Float
-				if sameOrder {
-					price, err = eth.TokenRatio(reserves.Reserve0, reserves.Reserve1)
-				} else {
-					price, err = eth.TokenRatio(reserves.Reserve1, reserves.Reserve0)
-				}
+				price, err := routePrice(opts, pairs)
 				if err != nil {
+					// the node may be reconnecting, keep watching
 					log.Printf("Failed to determine token price: %s\n", err)
+					time.Sleep(time.Second)
 					continue
 				}
-				p.currentPrice = price
+				p.setPrice(price)
 			}
 		}
 	}()
 	return nil
 }
 
-func isAtSameOrderAsPair(ctx context.Context, pair DexPair, tokenA, tokenB *eth.Token) (is bool, err error) {
+// routePrice multiplies the prices of the pairs along a route.
+func routePrice(opts *bind.CallOpts, pairs []pricedPair) (*big.Float, error) {
+	var price *big.Float
+	for _, p := range pairs {
+		reserves, err := p.pair.GetReserves(opts)
+		if err != nil {
+			return nil, fmt.Errorf("Error calling pair.GetReserves: %s", err)
+		}
+
+		var pairPrice *big.Float
+		if p.sameOrder {
+			pairPrice, err = eth.TokenRatio(reserves.Reserve0, reserves.Reserve1)
+		} else {
+			pairPrice, err = eth.TokenRatio(reserves.Reserve1, reserves.Reserve0)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if price == nil {
+			price = pairPrice
+		} else {
+			price.Mul(price, pairPrice)
+		}
+	}
+	return price, nil
+}
+
+func isAtSameOrderAsPair(ctx context.Context, pair DexPair, tokenA, tokenB common.Address) (is bool, err error) {
 	opts := &bind.CallOpts{
 		Pending:     false,
 		BlockNumber: nil,
@@ -115,10 +202,10 @@ func isAtSameOrderAsPair(ctx context.Context, pair DexPair, tokenA, tokenB *eth.
 		return false, err
 	}
 
-	if tokenA.Address == token0Addr && tokenB.Address == token1Addr {
+	if tokenA == token0Addr && tokenB == token1Addr {
 		return true, nil
 	}
-	if tokenA.Address == token1Addr && tokenB.Address == token0Addr {
+	if tokenA == token1Addr && tokenB == token0Addr {
 		return false, nil
 	}
 	return false, errors.New("Tokens passed are not the same as of pair")
@@ -0,0 +1,85 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// FindBestPath quotes amountIn from tokenIn to tokenOut along the direct pair
+// and through each of the base tokens, and returns the path with the largest
+// output along with that output.
+func (d *Dex) FindBestPath(ctx context.Context, amountIn *big.Int, tokenIn, tokenOut common.Address, bases []common.Address) ([]common.Address, *big.Int, error) {
+	opts := &bind.CallOpts{
+		Pending:     true,
+		BlockNumber: nil,
+		Context:     ctx,
+	}
+
+	var bestPath []common.Address
+	var bestOut *big.Int
+	for _, path := range d.candidatePaths(opts, tokenIn, tokenOut, bases) {
+		amounts, err := d.Router.GetAmountsOut(opts, amountIn, path)
+		if err != nil {
+			log.Printf("Failed to quote path %s: %s", FormatPath(path), err)
+			continue
+		}
+		out := amounts[len(amounts)-1]
+		if bestOut == nil || out.Cmp(bestOut) > 0 {
+			bestPath, bestOut = path, out
+		}
+	}
+	if bestPath == nil {
+		return nil, nil, errors.New("No path with liquidity found")
+	}
+	return bestPath, bestOut, nil
+}
+
+// candidatePaths lists the direct path and the paths through one base token
+// whose pairs all exist.
+func (d *Dex) candidatePaths(opts *bind.CallOpts, tokenIn, tokenOut common.Address, bases []common.Address) [][]common.Address {
+	var paths [][]common.Address
+	if d.hasPair(opts, tokenIn, tokenOut) {
+		paths = append(paths, []common.Address{tokenIn, tokenOut})
+	}
+	for _, base := range bases {
+		if base == tokenIn || base == tokenOut {
+			continue
+		}
+		if d.hasPair(opts, tokenIn, base) && d.hasPair(opts, base, tokenOut) {
+			paths = append(paths, []common.Address{tokenIn, base, tokenOut})
+		}
+	}
+	return paths
+}
+
+func (d *Dex) hasPair(opts *bind.CallOpts, tokenA, tokenB common.Address) bool {
+	pair, err := d.Factory.GetPair(opts, tokenA, tokenB)
+	if err != nil {
+		log.Printf("Failed to get pair of %s and %s: %s", tokenA.Hex(), tokenB.Hex(), err)
+		return false
+	}
+	return pair != (common.Address{})
+}
+
+// ReversePath returns the path going the other way.
+func ReversePath(path []common.Address) []common.Address {
+	reversed := make([]common.Address, len(path))
+	for i, token := range path {
+		reversed[len(path)-1-i] = token
+	}
+	return reversed
+}
+
+func FormatPath(path []common.Address) string {
+	hexes := make([]string, len(path))
+	for i, token := range path {
+		hexes[i] = token.Hex()
+	}
+	return strings.Join(hexes, " > ")
+}
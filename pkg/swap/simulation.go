@@ -0,0 +1,181 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+
+	eth "sniper/pkg/eth"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+)
+
+type TradeSimulation struct {
+	BuyTax      *big.Float
+	SellTax     *big.Float
+	SellReverts bool
+}
+
+// TradeSimulator chains a buy, an approve and a sell of the buy swap in a
+// single eth_call on the pending block, to find out how much the target token
+// taxes transfers and whether it can be sold at all. The liquidity of a
+// pending transaction is overridden into the pair for the call to trade
+// against it.
+type TradeSimulator struct {
+	client    *ethclient.Client
+	geth      *gethclient.Client
+	dex       *Dex
+	swap      *DexSwap
+	slots     *eth.TokenSlots
+	baseSlots *eth.TokenSlots
+}
+
+func NewTradeSimulator(client *ethclient.Client, geth *gethclient.Client, dex *Dex, buySwap *DexSwap) (*TradeSimulator, error) {
+	ctx := context.Background()
+	from, router := buySwap.FromWallet.Address(), dex.RouterContract.Address
+
+	slots, err := buySwap.TokenOut.FindStorageSlots(client, geth, ctx, from, router)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot override %s balances: %s", buySwap.TokenOut.Symbol, err)
+	}
+	baseSlots, err := buySwap.TokenIn.FindStorageSlots(client, geth, ctx, from, router)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot override %s balances: %s", buySwap.TokenIn.Symbol, err)
+	}
+
+	s := &TradeSimulator{
+		client:    client,
+		geth:      geth,
+		dex:       dex,
+		swap:      buySwap,
+		slots:     slots,
+		baseSlots: baseSlots,
+	}
+	return s, nil
+}
+
+// Simulate trades against the pair as it is once pendingTx, if any, is mined.
+// The wallet runs the buy, then the approve and the sell of what it bought,
+// each call seeing the state left by the one before.
+func (s *TradeSimulator) Simulate(ctx context.Context, pendingTx *types.Transaction) (*TradeSimulation, error) {
+	from := s.swap.FromWallet.Address()
+	router := s.dex.RouterContract.Address
+
+	reserveIn, reserveOut, overrides, err := s.liquidityOverrides(ctx, pendingTx)
+	if err != nil {
+		return nil, err
+	}
+
+	buy := *s.swap
+	buy.AmountOutMin = big.NewInt(0)
+	buyTx, err := buy.buildCall(s.dex.Router, ctx)
+	if err != nil {
+		return nil, err
+	}
+	overrides[from] = gethclient.OverrideAccount{Code: executorCode, Balance: buyTx.Value()}
+
+	balanceOf, err := s.swap.TokenOut.ABI.Pack("balanceOf", from)
+	if err != nil {
+		return nil, err
+	}
+	token := s.swap.TokenOut.Address
+	buyCall := executorCall{To: router, Value: buyTx.Value(), Data: buyTx.Data()}
+
+	results, err := s.execute(ctx, overrides, []executorCall{
+		{To: token, Data: balanceOf},
+		buyCall,
+		{To: token, Data: balanceOf},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to simulate buy: %s", err)
+	}
+	if len(results) < 3 {
+		return nil, errors.New("buy reverts")
+	}
+	bought := new(big.Int).Sub(new(big.Int).SetBytes(results[2].Return), new(big.Int).SetBytes(results[0].Return))
+	if bought.Sign() <= 0 {
+		return nil, errors.New("buy receives no tokens")
+	}
+	spent := new(big.Int).Sub(buyTx.Value(), results[1].Balance)
+	buyQuote := s.dex.GetAmountOut(spent, reserveIn, reserveOut)
+
+	approve, err := s.swap.TokenOut.ABI.Pack("approve", router, bought)
+	if err != nil {
+		return nil, err
+	}
+	sell := &DexSwap{
+		SwapFunc:     ExactTokensForEth,
+		FromWallet:   s.swap.FromWallet,
+		TokenIn:      s.swap.TokenOut,
+		TokenOut:     s.swap.TokenIn,
+		AmountIn:     bought,
+		AmountOutMin: big.NewInt(0),
+		Expiration:   s.swap.Expiration,
+		Route:        ReversePath(s.swap.Path()),
+	}
+	sellTx, err := sell.buildCall(s.dex.Router, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err = s.execute(ctx, overrides, []executorCall{
+		buyCall,
+		{To: token, Data: approve},
+		{To: router, Data: sellTx.Data()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to simulate sell: %s", err)
+	}
+
+	res := &TradeSimulation{
+		BuyTax:      taxRate(bought, buyQuote),
+		SellReverts: len(results) < 3,
+	}
+	if res.SellReverts {
+		return res, nil
+	}
+	// the sell trades against the reserves the buy left
+	sellQuote := s.dex.GetAmountOut(bought, new(big.Int).Sub(reserveOut, buyQuote), new(big.Int).Add(reserveIn, spent))
+	sold := new(big.Int).Sub(results[2].Balance, results[0].Balance)
+	res.SellTax = taxRate(sold, sellQuote)
+
+	return res, nil
+}
+
+// execute runs the calls from the wallet, returning the results of those that
+// did not revert.
+func (s *TradeSimulator) execute(ctx context.Context, overrides map[common.Address]gethclient.OverrideAccount, calls []executorCall) ([]executorResult, error) {
+	from := s.swap.FromWallet.Address()
+	msg := ethereum.CallMsg{From: from, To: &from, Data: packExecutorCalls(calls)}
+	out, err := s.geth.CallContract(ctx, msg, eth.PendingBlock, &overrides)
+	if err != nil {
+		return nil, err
+	}
+	return unpackExecutorResults(out)
+}
+
+func taxRate(received, quoted *big.Int) *big.Float {
+	if quoted.Sign() == 0 {
+		return big.NewFloat(1)
+	}
+	ratio, _ := eth.TokenRatio(received, quoted)
+	return new(big.Float).Sub(big.NewFloat(1), ratio)
+}
+
+func (r *TradeSimulation) Log(symbol string) {
+	if r.SellReverts {
+		log.Printf("Simulated trade of %s: buy tax %.2f%%, sell reverts", symbol, percent(r.BuyTax))
+		return
+	}
+	log.Printf("Simulated trade of %s: buy tax %.2f%%, sell tax %.2f%%", symbol, percent(r.BuyTax), percent(r.SellTax))
+}
+
+func percent(rate *big.Float) *big.Float {
+	return new(big.Float).Mul(rate, big.NewFloat(100))
+}
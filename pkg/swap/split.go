@@ -0,0 +1,45 @@
+package swap
+
+import (
+	"math/big"
+
+	eth "sniper/pkg/eth"
+)
+
+// Split divides the swap into one swap per wallet, sharing AmountIn evenly
+// along with a set AmountOutQuote, AmountOutMin or AmountOut. The first wallet
+// takes the remainder of the division.
+func (s *DexSwap) Split(wallets []*eth.Wallet) []*DexSwap {
+	if len(wallets) == 0 {
+		return nil
+	}
+
+	n := big.NewInt(int64(len(wallets)))
+	share := new(big.Int).Div(s.AmountIn, n)
+	remainder := new(big.Int).Sub(s.AmountIn, new(big.Int).Mul(share, n))
+
+	parts := make([]*DexSwap, len(wallets))
+	for i, wallet := range wallets {
+		amountIn := new(big.Int).Set(share)
+		if i == 0 {
+			amountIn.Add(amountIn, remainder)
+		}
+
+		part := *s
+		part.FromWallet = wallet
+		part.AmountIn = amountIn
+		part.AmountOutQuote = scaleAmount(s.AmountOutQuote, amountIn, s.AmountIn)
+		part.AmountOutMin = scaleAmount(s.AmountOutMin, amountIn, s.AmountIn)
+		part.AmountOut = scaleAmount(s.AmountOut, amountIn, s.AmountIn)
+		parts[i] = &part
+	}
+	return parts
+}
+
+func scaleAmount(amount, num, denom *big.Int) *big.Int {
+	if amount == nil || denom.Sign() == 0 {
+		return amount
+	}
+	scaled := new(big.Int).Mul(amount, num)
+	return scaled.Div(scaled, denom)
+}
@@ -2,16 +2,21 @@ package swap
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"math/big"
 	"time"
 
+	pancake "sniper/contracts/bsc/pancakeswap"
 	eth "sniper/pkg/eth"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 type DexSwap struct {
@@ -21,21 +26,192 @@ type DexSwap struct {
 	TokenOut    *eth.Token
 	AmountIn    *big.Int
 	Expiration  *big.Int
-	GasStrategy string
+	SlippageBps int64
+	GasStrategy eth.GasStrategy
+	// GasLimit is estimated when zero
+	GasLimit uint64
+	// AmountOutQuote replaces the router quote, for swaps built before the
+	// liquidity they trade against is mined
+	AmountOutQuote *big.Int
+	// AmountOutMin replaces the quote with slippage altogether
+	AmountOutMin *big.Int
+	// Route replaces the direct path from TokenIn to TokenOut
+	Route []common.Address
+	// AmountOut is the exact output of exact-output swaps, which spend at
+	// most AmountIn
+	AmountOut *big.Int
 }
 
+const DefaultSlippageBps = 50
+
 func (s *DexSwap) GetTxDeadlineFromNow() *big.Int {
 	now := big.NewInt(time.Now().Unix())
 	return new(big.Int).Add(now, s.Expiration)
 }
 
+func (s *DexSwap) Path() []common.Address {
+	if len(s.Route) > 0 {
+		return s.Route
+	}
+	return []common.Address{s.TokenIn.Address, s.TokenOut.Address}
+}
+
+// GetAmountOutMin quotes the swap output through the router, unless
+// AmountOutQuote is set, and discounts the slippage tolerance from it. A set
+// AmountOutMin is returned as is.
+func (s *DexSwap) GetAmountOutMin(router DexRouter, ctx context.Context) (*big.Int, error) {
+	if s.AmountOutMin != nil {
+		return s.AmountOutMin, nil
+	}
+
+	quote := s.AmountOutQuote
+	if quote == nil {
+		opts := &bind.CallOpts{
+			Pending:     true,
+			BlockNumber: nil,
+			Context:     ctx,
+		}
+		amounts, err := router.GetAmountsOut(opts, s.AmountIn, s.Path())
+		if err != nil {
+			return nil, fmt.Errorf("Failed to quote swap output: %s", err)
+		}
+		quote = amounts[len(amounts)-1]
+	}
+	amountOutMin := ApplySlippage(quote, s.SlippageBps)
+
+	log.Printf(
+		"Quoted %.18f %s for %.18f %s, minimum output %.18f %s with %d bps slippage",
+		eth.FromWei(quote, params.Ether), s.TokenOut.Symbol,
+		eth.FromWei(s.AmountIn, params.Ether), s.TokenIn.Symbol,
+		eth.FromWei(amountOutMin, params.Ether), s.TokenOut.Symbol, s.SlippageBps,
+	)
+	return amountOutMin, nil
+}
+
+func ApplySlippage(amount *big.Int, slippageBps int64) *big.Int {
+	min := new(big.Int).Mul(amount, big.NewInt(10000-slippageBps))
+	return min.Div(min, big.NewInt(10000))
+}
+
+// AddSlippage is the opposite of ApplySlippage, for the most an exact-output
+// swap may spend.
+func AddSlippage(amount *big.Int, slippageBps int64) *big.Int {
+	max := new(big.Int).Mul(amount, big.NewInt(10000+slippageBps))
+	return max.Div(max, big.NewInt(10000))
+}
+
+// QuoteAmountIn quotes the input of an exact-output swap through the router.
+func (s *DexSwap) QuoteAmountIn(router DexRouter, ctx context.Context) (*big.Int, error) {
+	opts := &bind.CallOpts{
+		Pending:     true,
+		BlockNumber: nil,
+		Context:     ctx,
+	}
+	amounts, err := router.GetAmountsIn(opts, s.AmountOut, s.Path())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to quote swap input: %s", err)
+	}
+	return amounts[0], nil
+}
+
+// LimitAmountIn lowers AmountIn of an exact-output swap to the quoted input
+// with slippage, or sets it when no AmountIn was given. A quote above a set
+// AmountIn refuses the swap, which would revert.
+func (s *DexSwap) LimitAmountIn(quote *big.Int) error {
+	unset := s.AmountIn == nil || s.AmountIn.Sign() == 0
+	if !unset && quote.Cmp(s.AmountIn) > 0 {
+		return fmt.Errorf(
+			"%.18f %s cost %.18f %s, above the max input of %.18f",
+			eth.FromWei(s.AmountOut, params.Ether), s.TokenOut.Symbol,
+			eth.FromWei(quote, params.Ether), s.TokenIn.Symbol, eth.FromWei(s.AmountIn, params.Ether),
+		)
+	}
+
+	amountInMax := AddSlippage(quote, s.SlippageBps)
+	if unset || amountInMax.Cmp(s.AmountIn) < 0 {
+		s.AmountIn = amountInMax
+	}
+	log.Printf(
+		"Quoted %.18f %s for %.18f %s, maximum input %.18f %s with %d bps slippage",
+		eth.FromWei(quote, params.Ether), s.TokenIn.Symbol,
+		eth.FromWei(s.AmountOut, params.Ether), s.TokenOut.Symbol,
+		eth.FromWei(s.AmountIn, params.Ether), s.TokenIn.Symbol, s.SlippageBps,
+	)
+	return nil
+}
+
+// MinAmountOutAtPrice is the output of amountIn at maxPrice, below which the
+// swap pays more than maxPrice.
+func MinAmountOutAtPrice(amountIn *big.Int, maxPrice *big.Float) *big.Int {
+	out := new(big.Float).SetPrec(256).SetInt(amountIn)
+	out.Quo(out, maxPrice)
+	min, _ := out.Int(nil)
+	return min
+}
+
+// LimitToMaxPrice checks the execution price of the swap against the given
+// reserves. Above maxPrice, AmountIn is scaled down to the largest amount that
+// stays within it when scaleDown is set, otherwise the swap is refused.
+func (s *DexSwap) LimitToMaxPrice(dex *Dex, reserveIn, reserveOut *big.Int, maxPrice *big.Float, scaleDown bool) error {
+	price, err := dex.GetExecutionPrice(s.AmountIn, reserveIn, reserveOut)
+	if err != nil {
+		return fmt.Errorf("Failed to get execution price: %s", err)
+	}
+	log.Printf(
+		"Execution price for %.18f %s is %.18f %s per %s (max %.18f), reserves %.18f %s / %.18f %s",
+		eth.FromWei(s.AmountIn, params.Ether), s.TokenIn.Symbol,
+		price, s.TokenIn.Symbol, s.TokenOut.Symbol, maxPrice,
+		eth.FromWei(reserveIn, params.Ether), s.TokenIn.Symbol,
+		eth.FromWei(reserveOut, params.Ether), s.TokenOut.Symbol,
+	)
+	if price.Cmp(maxPrice) <= 0 {
+		return nil
+	}
+
+	if !scaleDown {
+		return fmt.Errorf("execution price %.18f is above max buy price %.18f", price, maxPrice)
+	}
+	maxAmountIn := dex.GetMaxAmountIn(maxPrice, reserveIn, reserveOut)
+	if maxAmountIn.Sign() <= 0 {
+		return fmt.Errorf("spot price is already above max buy price %.18f", maxPrice)
+	}
+
+	log.Printf(
+		"Scaling buy down from %.18f to %.18f %s to stay under max buy price",
+		eth.FromWei(s.AmountIn, params.Ether), eth.FromWei(maxAmountIn, params.Ether), s.TokenIn.Symbol,
+	)
+	s.ScaleAmountIn(maxAmountIn)
+	return nil
+}
+
+// ScaleAmountIn changes AmountIn, scaling a set AmountOutQuote, AmountOutMin
+// or AmountOut along with it.
+func (s *DexSwap) ScaleAmountIn(amountIn *big.Int) {
+	s.AmountOutQuote = scaleAmount(s.AmountOutQuote, amountIn, s.AmountIn)
+	s.AmountOutMin = scaleAmount(s.AmountOutMin, amountIn, s.AmountIn)
+	s.AmountOut = scaleAmount(s.AmountOut, amountIn, s.AmountIn)
+	s.AmountIn = amountIn
+}
+
+// BuildTxOpts reserves the next nonce of the wallet for the transaction. It
+// has to be released when the transaction is not sent.
 func (s *DexSwap) BuildTxOpts(client *ethclient.Client, ctx context.Context) (*bind.TransactOpts, error) {
-	nonce, err := client.PendingNonceAt(ctx, s.FromWallet.Address())
+	opts, err := s.buildTxOpts(client, ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	gasPrice, err := client.SuggestGasPrice(ctx)
+	nonce, err := s.FromWallet.Nonces.Next(client, ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts.Nonce = new(big.Int).SetUint64(nonce)
+
+	return opts, nil
+}
+
+func (s *DexSwap) buildTxOpts(client *ethclient.Client, ctx context.Context) (*bind.TransactOpts, error) {
+	fees, err := eth.GetGasFees(client, ctx, s.GasStrategy)
 	if err != nil {
 		return nil, err
 	}
@@ -45,10 +221,13 @@ func (s *DexSwap) BuildTxOpts(client *ethclient.Client, ctx context.Context) (*b
 		return nil, err
 	}
 
-	opts.Nonce = new(big.Int).SetUint64(nonce)
-	opts.Value = s.AmountIn
-	opts.GasPrice = gasPrice
-	opts.GasLimit = 0
+	if fees.IsDynamic() {
+		opts.GasFeeCap = fees.GasFeeCap
+		opts.GasTipCap = fees.GasTipCap
+	} else {
+		opts.GasPrice = fees.GasPrice
+	}
+	opts.GasLimit = s.GasLimit
 	opts.Context = ctx
 	opts.NoSend = true
 
@@ -63,31 +242,204 @@ func (s *DexSwap) BuildTx(client *ethclient.Client, ctx context.Context, router
 
 	tx, err := s.SwapFunc(router, s, opts)
 	if err != nil {
+		s.FromWallet.Nonces.Release(opts.Nonce.Uint64())
 		return nil, fmt.Errorf("Failed to build contract method call: %s\n", err)
 	}
 
 	return tx, nil
 }
 
+// NeedsApproval tells whether spender is allowed less than AmountIn of
+// TokenIn.
+func (s *DexSwap) NeedsApproval(ctx context.Context, spender common.Address) (bool, error) {
+	allowance, err := s.TokenIn.Allowance(&bind.CallOpts{Context: ctx}, s.FromWallet.Address(), spender)
+	if err != nil {
+		return false, fmt.Errorf("Failed to get %s allowance: %s", s.TokenIn.Symbol, err)
+	}
+	return allowance.Cmp(s.AmountIn) < 0, nil
+}
+
+func (s *DexSwap) BuildApproveTx(client *ethclient.Client, ctx context.Context, spender common.Address) (*types.Transaction, error) {
+	opts, err := s.BuildTxOpts(client, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build approve transaction options: %s\n", err)
+	}
+
+	tx, err := s.TokenIn.Approve(opts, spender, s.AmountIn)
+	if err != nil {
+		s.FromWallet.Nonces.Release(opts.Nonce.Uint64())
+		return nil, fmt.Errorf("Failed to build approve call: %s\n", err)
+	}
+
+	return tx, nil
+}
+
+// GetSwapAmounts reads the amounts swapped from the pair Swap events of a receipt.
+func GetSwapAmounts(receipt *types.Receipt) (amountIn, amountOut *big.Int, err error) {
+	pairABI, err := pancake.PancakePairMetaData.GetAbi()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot get pair ABI: %s", err)
+	}
+	swapEvent := pairABI.Events["Swap"]
+
+	var swapLogs []map[string]interface{}
+	for _, txLog := range receipt.Logs {
+		if len(txLog.Topics) == 0 || txLog.Topics[0] != swapEvent.ID {
+			continue
+		}
+		logData := make(map[string]interface{})
+		err = swapEvent.Inputs.NonIndexed().UnpackIntoMap(logData, txLog.Data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Cannot decode Swap event: %s", err)
+		}
+		swapLogs = append(swapLogs, logData)
+	}
+	if len(swapLogs) == 0 {
+		return nil, nil, errors.New("No Swap event found in receipt")
+	}
+
+	// only one side of each pair amount is set, the first hop holds the
+	// input and the last one holds the output
+	first, last := swapLogs[0], swapLogs[len(swapLogs)-1]
+	amountIn = new(big.Int).Add(first["amount0In"].(*big.Int), first["amount1In"].(*big.Int))
+	amountOut = new(big.Int).Add(last["amount0Out"].(*big.Int), last["amount1Out"].(*big.Int))
+
+	return amountIn, amountOut, nil
+}
+
+var transferEventID = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// GetReceivedAmount sums the transfers of token to wallet in a receipt, which
+// for taxed tokens is less than the pair sent out.
+func GetReceivedAmount(receipt *types.Receipt, token, wallet common.Address) *big.Int {
+	return sumTransfers(receipt, token, func(from, to common.Address) bool { return to == wallet })
+}
+
+// GetSentAmount sums the transfers of token from wallet in a receipt, taxes
+// included, which is more than the pair received for taxed tokens.
+func GetSentAmount(receipt *types.Receipt, token, wallet common.Address) *big.Int {
+	return sumTransfers(receipt, token, func(from, to common.Address) bool { return from == wallet })
+}
+
+func sumTransfers(receipt *types.Receipt, token common.Address, matches func(from, to common.Address) bool) *big.Int {
+	total := big.NewInt(0)
+	for _, txLog := range receipt.Logs {
+		if txLog.Address != token || len(txLog.Topics) != 3 || txLog.Topics[0] != transferEventID {
+			continue
+		}
+		from, to := common.BytesToAddress(txLog.Topics[1].Bytes()), common.BytesToAddress(txLog.Topics[2].Bytes())
+		if matches(from, to) {
+			total.Add(total, new(big.Int).SetBytes(txLog.Data))
+		}
+	}
+	return total
+}
+
 // Supported swap methods
 type swapFuncWrapper func(router DexRouter, swap *DexSwap, opts *bind.TransactOpts) (*types.Transaction, error)
 
 func ExactEthForTokens(router DexRouter, swap *DexSwap, opts *bind.TransactOpts) (*types.Transaction, error) {
+	amountOutMin, err := swap.GetAmountOutMin(router, opts.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Value = swap.AmountIn
 	return router.SwapExactETHForTokensSupportingFeeOnTransferTokens(
 		opts,
-		big.NewInt(0), // amountOutMin
-		[]common.Address{swap.TokenIn.Address, swap.TokenOut.Address},
+		amountOutMin,
+		swap.Path(),
+		swap.FromWallet.Address(),
+		swap.GetTxDeadlineFromNow(),
+	)
+}
+
+func ExactTokensForTokens(router DexRouter, swap *DexSwap, opts *bind.TransactOpts) (*types.Transaction, error) {
+	amountOutMin, err := swap.GetAmountOutMin(router, opts.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	return router.SwapExactTokensForTokensSupportingFeeOnTransferTokens(
+		opts,
+		swap.AmountIn,
+		amountOutMin,
+		swap.Path(),
 		swap.FromWallet.Address(),
 		swap.GetTxDeadlineFromNow(),
 	)
 }
 
 func ExactTokensForEth(router DexRouter, swap *DexSwap, opts *bind.TransactOpts) (*types.Transaction, error) {
+	amountOutMin, err := swap.GetAmountOutMin(router, opts.Context)
+	if err != nil {
+		return nil, err
+	}
+
 	return router.SwapExactTokensForETHSupportingFeeOnTransferTokens(
 		opts,
-		swap.AmountIn, // amountIn
-		big.NewInt(0), // amountOutMin
-		[]common.Address{swap.TokenIn.Address, swap.TokenOut.Address},
+		swap.AmountIn,
+		amountOutMin,
+		swap.Path(),
+		swap.FromWallet.Address(),
+		swap.GetTxDeadlineFromNow(),
+	)
+}
+
+// exactOutputAmountIn is the most an exact-output swap spends, which has to
+// be set beforehand.
+func exactOutputAmountIn(swap *DexSwap) (*big.Int, error) {
+	if swap.AmountOut == nil || swap.AmountIn == nil || swap.AmountIn.Sign() == 0 {
+		return nil, errors.New("Exact-output swap needs AmountOut and a max AmountIn")
+	}
+	return swap.AmountIn, nil
+}
+
+// EthForExactTokens buys AmountOut of the token, the router refunding what is
+// left of AmountIn.
+func EthForExactTokens(router DexRouter, swap *DexSwap, opts *bind.TransactOpts) (*types.Transaction, error) {
+	amountInMax, err := exactOutputAmountIn(swap)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Value = amountInMax
+	return router.SwapETHForExactTokens(
+		opts,
+		swap.AmountOut,
+		swap.Path(),
+		swap.FromWallet.Address(),
+		swap.GetTxDeadlineFromNow(),
+	)
+}
+
+func TokensForExactTokens(router DexRouter, swap *DexSwap, opts *bind.TransactOpts) (*types.Transaction, error) {
+	amountInMax, err := exactOutputAmountIn(swap)
+	if err != nil {
+		return nil, err
+	}
+
+	return router.SwapTokensForExactTokens(
+		opts,
+		swap.AmountOut,
+		amountInMax,
+		swap.Path(),
+		swap.FromWallet.Address(),
+		swap.GetTxDeadlineFromNow(),
+	)
+}
+
+func TokensForExactEth(router DexRouter, swap *DexSwap, opts *bind.TransactOpts) (*types.Transaction, error) {
+	amountInMax, err := exactOutputAmountIn(swap)
+	if err != nil {
+		return nil, err
+	}
+
+	return router.SwapTokensForExactETH(
+		opts,
+		swap.AmountOut,
+		amountInMax,
+		swap.Path(),
 		swap.FromWallet.Address(),
 		swap.GetTxDeadlineFromNow(),
 	)
@@ -0,0 +1,448 @@
+package swap
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	pancake "sniper/contracts/bsc/pancakeswap"
+	eth "sniper/pkg/eth"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/stretchr/testify/assert"
+)
+
+var slippageTestCases = []struct {
+	amount   int64
+	bps      int64
+	expected int64
+}{
+	{1000000, 0, 1000000},
+	{1000000, 50, 995000},
+	{1000000, 100, 990000},
+	{1000000, 2500, 750000},
+	{999, 50, 994},
+}
+
+func TestApplySlippage(t *testing.T) {
+	for _, tc := range slippageTestCases {
+		min := ApplySlippage(big.NewInt(tc.amount), tc.bps)
+		assert.Equal(t, big.NewInt(tc.expected).String(), min.String(), "wrong minimum for %d bps", tc.bps)
+	}
+}
+
+func TestGetAmountOut(t *testing.T) {
+	dex := &Dex{FeeBps: 25}
+	amountOut := dex.GetAmountOut(big.NewInt(1000), big.NewInt(1000000), big.NewInt(2000000))
+	// 1000*9975*2000000 / (1000000*10000 + 1000*9975)
+	assert.Equal(t, "1993", amountOut.String())
+}
+
+func TestGetAmountIn(t *testing.T) {
+	dex := &Dex{FeeBps: 25}
+	amountIn, err := dex.GetAmountIn(big.NewInt(1993), big.NewInt(1000000), big.NewInt(2000000))
+	assert.NoError(t, err)
+	assert.Equal(t, "1000", amountIn.String(), "should buy back the output of TestGetAmountOut")
+
+	_, err = dex.GetAmountIn(big.NewInt(2000000), big.NewInt(1000000), big.NewInt(2000000))
+	assert.Error(t, err, "output cannot drain the pair")
+}
+
+func TestLimitAmountIn(t *testing.T) {
+	token := &eth.Token{Contract: &eth.Contract{}}
+	sw := &DexSwap{TokenIn: token, TokenOut: token, AmountIn: big.NewInt(0), AmountOut: big.NewInt(500), SlippageBps: 100}
+	assert.NoError(t, sw.LimitAmountIn(big.NewInt(1000)))
+	assert.Equal(t, "1010", sw.AmountIn.String(), "unset max input should be the quote with slippage")
+
+	sw.AmountIn = big.NewInt(1005)
+	assert.NoError(t, sw.LimitAmountIn(big.NewInt(1000)))
+	assert.Equal(t, "1005", sw.AmountIn.String(), "max input should not be raised")
+
+	assert.Error(t, sw.LimitAmountIn(big.NewInt(1006)))
+}
+
+func TestGetMaxAmountIn(t *testing.T) {
+	dex := &Dex{FeeBps: 25}
+	reserveIn, _ := new(big.Int).SetString("50000000000000000000", 10)
+	reserveOut, _ := new(big.Int).SetString("1000000000000000000000000", 10)
+	maxPrice := big.NewFloat(0.0001)
+
+	maxAmountIn := dex.GetMaxAmountIn(maxPrice, reserveIn, reserveOut)
+	assert.Equal(t, 1, maxAmountIn.Sign(), "max amount should be positive")
+
+	price, err := dex.GetExecutionPrice(maxAmountIn, reserveIn, reserveOut)
+	assert.NoError(t, err)
+	assert.True(t, price.Cmp(big.NewFloat(0.000100001)) <= 0, "price %s above max", price.String())
+
+	above := new(big.Int).Add(maxAmountIn, big.NewInt(1e15))
+	price, err = dex.GetExecutionPrice(above, reserveIn, reserveOut)
+	assert.NoError(t, err)
+	assert.True(t, price.Cmp(maxPrice) > 0, "price %s should be above max", price.String())
+
+	tooExpensive := dex.GetMaxAmountIn(big.NewFloat(0.00001), reserveIn, reserveOut)
+	assert.Equal(t, "0", tooExpensive.String())
+}
+
+func TestSearchMaxPassing(t *testing.T) {
+	max := big.NewInt(1000000)
+	received := big.NewInt(873456)
+
+	found, err := searchMaxPassing(max, func(amount *big.Int) (bool, error) {
+		return amount.Cmp(received) <= 0, nil
+	})
+	assert.NoError(t, err)
+	diff := new(big.Int).Sub(received, found)
+	assert.True(t, diff.Sign() >= 0 && diff.Cmp(big.NewInt(100)) <= 0, "found %s, expected close to %s", found, received)
+
+	found, err = searchMaxPassing(max, func(amount *big.Int) (bool, error) {
+		return false, nil
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+func TestBudget(t *testing.T) {
+	budget := NewBudget(big.NewInt(100))
+
+	assert.Equal(t, "60", budget.Reserve(big.NewInt(60)).String())
+	assert.Equal(t, "40", budget.Reserve(big.NewInt(60)).String(), "should grant what is left")
+	assert.Equal(t, "0", budget.Reserve(big.NewInt(10)).String())
+
+	budget.Release(big.NewInt(25))
+	assert.Equal(t, "25", budget.Remaining().String())
+	budget.Release(big.NewInt(-5))
+	assert.Equal(t, "25", budget.Remaining().String(), "negative releases should be ignored")
+}
+
+func TestMinAmountOutAtPrice(t *testing.T) {
+	min := MinAmountOutAtPrice(big.NewInt(1000), big.NewFloat(0.5))
+	assert.Equal(t, "2000", min.String())
+
+	sw := &DexSwap{AmountIn: big.NewInt(1000), AmountOutMin: min}
+	sw.ScaleAmountIn(big.NewInt(250))
+	assert.Equal(t, "250", sw.AmountIn.String())
+	assert.Equal(t, "500", sw.AmountOutMin.String(), "minimum output should keep the same price")
+}
+
+func TestSplit(t *testing.T) {
+	wallets := []*eth.Wallet{{}, {}, {}}
+	sw := &DexSwap{AmountIn: big.NewInt(1000), AmountOutMin: big.NewInt(2000)}
+
+	parts := sw.Split(wallets)
+	assert.Len(t, parts, 3)
+	assert.Equal(t, "334", parts[0].AmountIn.String(), "first wallet should take the remainder")
+	assert.Equal(t, "333", parts[1].AmountIn.String())
+	assert.Equal(t, "666", parts[1].AmountOutMin.String())
+	assert.Nil(t, parts[2].AmountOutQuote)
+	assert.Same(t, wallets[2], parts[2].FromWallet)
+	assert.Equal(t, "1000", sw.AmountIn.String(), "split swap should be left untouched")
+}
+
+func TestPath(t *testing.T) {
+	in, base, out := common.HexToAddress("0x1"), common.HexToAddress("0x2"), common.HexToAddress("0x3")
+	sw := &DexSwap{
+		TokenIn:  &eth.Token{Contract: &eth.Contract{Address: in}},
+		TokenOut: &eth.Token{Contract: &eth.Contract{Address: out}},
+	}
+	assert.Equal(t, []common.Address{in, out}, sw.Path())
+
+	sw.Route = []common.Address{in, base, out}
+	assert.Equal(t, []common.Address{in, base, out}, sw.Path())
+	assert.Equal(t, []common.Address{out, base, in}, ReversePath(sw.Path()))
+	assert.Equal(t, []common.Address{in, base, out}, sw.Route, "reversing should leave the route untouched")
+}
+
+func TestExecutor(t *testing.T) {
+	db, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	assert.Nil(t, err)
+	executor := common.BytesToAddress([]byte("contract"))
+	db.AddBalance(executor, big.NewInt(100))
+
+	// returns the value it was sent, and reverts
+	echo, reverts := common.HexToAddress("0x1001"), common.HexToAddress("0x1002")
+	db.SetCode(echo, common.FromHex("0x3460005260206000f3"))
+	db.SetCode(reverts, common.FromHex("0x60006000fd"))
+
+	input := packExecutorCalls([]executorCall{
+		{To: echo, Value: big.NewInt(30), Data: []byte{1, 2, 3}},
+		{To: common.HexToAddress("0x1003")},
+		{To: reverts},
+		{To: echo, Value: big.NewInt(1)},
+	})
+	out, _, err := runtime.Execute(executorCode, input, &runtime.Config{State: db})
+	assert.Nil(t, err)
+
+	results, err := unpackExecutorResults(out)
+	assert.Nil(t, err)
+	if assert.Len(t, results, 2, "calls should stop at the first revert") {
+		assert.Equal(t, "70", results[0].Balance.String())
+		assert.Equal(t, "30", new(big.Int).SetBytes(results[0].Return).String())
+		assert.Equal(t, "70", results[1].Balance.String())
+		assert.Empty(t, results[1].Return)
+	}
+}
+
+func pairKey(a, b common.Address) [2]common.Address {
+	if bytes.Compare(a.Bytes(), b.Bytes()) > 0 {
+		a, b = b, a
+	}
+	return [2]common.Address{a, b}
+}
+
+// fakeDex quotes every pair as swapping one token for rate tokens.
+type fakeDex struct {
+	DexRouter
+	DexFactory
+	rates map[[2]common.Address]int64
+}
+
+func (d *fakeDex) GetPair(opts *bind.CallOpts, tokenA, tokenB common.Address) (common.Address, error) {
+	if _, ok := d.rates[pairKey(tokenA, tokenB)]; !ok {
+		return common.Address{}, nil
+	}
+	return common.HexToAddress("0x2001"), nil
+}
+
+func (d *fakeDex) GetAmountsOut(opts *bind.CallOpts, amountIn *big.Int, path []common.Address) ([]*big.Int, error) {
+	amounts := []*big.Int{amountIn}
+	for i := 0; i+1 < len(path); i++ {
+		rate := d.rates[pairKey(path[i], path[i+1])]
+		amounts = append(amounts, new(big.Int).Mul(amounts[i], big.NewInt(rate)))
+	}
+	return amounts, nil
+}
+
+func TestFindBestPath(t *testing.T) {
+	in, out := common.HexToAddress("0x1001"), common.HexToAddress("0x1002")
+	wbnb, usdt := common.HexToAddress("0x1003"), common.HexToAddress("0x1004")
+	bases := []common.Address{wbnb, usdt}
+
+	testCases := []struct {
+		name       string
+		rates      map[[2]common.Address]int64
+		candidates [][]common.Address
+		best       []common.Address
+	}{
+		{
+			name:       "direct pair only",
+			rates:      map[[2]common.Address]int64{pairKey(in, out): 2},
+			candidates: [][]common.Address{{in, out}},
+			best:       []common.Address{in, out},
+		},
+		{
+			name: "base quoting more than the direct pair",
+			rates: map[[2]common.Address]int64{
+				pairKey(in, out): 2, pairKey(in, wbnb): 3, pairKey(wbnb, out): 1,
+				pairKey(in, usdt): 1, pairKey(usdt, out): 5,
+			},
+			candidates: [][]common.Address{{in, out}, {in, wbnb, out}, {in, usdt, out}},
+			best:       []common.Address{in, usdt, out},
+		},
+		{
+			name:       "base missing a pair",
+			rates:      map[[2]common.Address]int64{pairKey(in, wbnb): 3, pairKey(usdt, out): 5, pairKey(wbnb, out): 1},
+			candidates: [][]common.Address{{in, wbnb, out}},
+			best:       []common.Address{in, wbnb, out},
+		},
+		{
+			name:  "no pair",
+			rates: map[[2]common.Address]int64{pairKey(in, wbnb): 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		fake := &fakeDex{rates: tc.rates}
+		dex := &Dex{Factory: fake, Router: fake}
+		candidates := dex.candidatePaths(&bind.CallOpts{}, in, out, bases)
+		assert.Equal(t, tc.candidates, candidates, tc.name)
+
+		path, _, err := dex.FindBestPath(context.Background(), big.NewInt(10), in, out, bases)
+		if tc.best == nil {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.best, path, tc.name)
+	}
+}
+
+type fakePair struct {
+	reserve0, reserve1 int64
+}
+
+func (p *fakePair) GetReserves(opts *bind.CallOpts) (struct {
+	Reserve0           *big.Int
+	Reserve1           *big.Int
+	BlockTimestampLast uint32
+}, error) {
+	reserves := struct {
+		Reserve0           *big.Int
+		Reserve1           *big.Int
+		BlockTimestampLast uint32
+	}{Reserve0: big.NewInt(p.reserve0), Reserve1: big.NewInt(p.reserve1)}
+	return reserves, nil
+}
+
+func (p *fakePair) Token0(opts *bind.CallOpts) (common.Address, error) {
+	return common.Address{}, nil
+}
+
+func (p *fakePair) Token1(opts *bind.CallOpts) (common.Address, error) {
+	return common.Address{}, nil
+}
+
+func TestRoutePrice(t *testing.T) {
+	testCases := []struct {
+		name     string
+		pairs    []pricedPair
+		expected float64
+	}{
+		{"same order", []pricedPair{{&fakePair{1000, 4000}, true}}, 0.25},
+		{"reversed order", []pricedPair{{&fakePair{1000, 4000}, false}}, 4},
+		{"two hops", []pricedPair{{&fakePair{1000, 4000}, true}, {&fakePair{3000, 1000}, false}}, 0.25 / 3},
+	}
+
+	for _, tc := range testCases {
+		price, err := routePrice(&bind.CallOpts{}, tc.pairs)
+		assert.NoError(t, err, tc.name)
+		actual, _ := price.Float64()
+		assert.InDelta(t, tc.expected, actual, 1e-9, tc.name)
+	}
+}
+
+func TestTransferredAmounts(t *testing.T) {
+	token, other := common.HexToAddress("0x1001"), common.HexToAddress("0x1002")
+	wallet, pair, taxes := common.HexToAddress("0x2001"), common.HexToAddress("0x2002"), common.HexToAddress("0x2003")
+	transfer := func(token, from, to common.Address, amount int64) *types.Log {
+		return &types.Log{
+			Address: token,
+			Topics:  []common.Hash{transferEventID, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+			Data:    common.LeftPadBytes(big.NewInt(amount).Bytes(), 32),
+		}
+	}
+
+	// a 10% tax taken on both the buy and the sell
+	buy := &types.Receipt{Logs: []*types.Log{
+		transfer(token, pair, taxes, 100),
+		transfer(token, pair, wallet, 900),
+		transfer(other, pair, wallet, 5),
+	}}
+	assert.Equal(t, "900", GetReceivedAmount(buy, token, wallet).String(), "the tax never reaches the wallet")
+	assert.Equal(t, "0", GetSentAmount(buy, token, wallet).String())
+
+	sell := &types.Receipt{Logs: []*types.Log{
+		transfer(token, wallet, taxes, 90),
+		transfer(token, wallet, pair, 810),
+	}}
+	assert.Equal(t, "900", GetSentAmount(sell, token, wallet).String(), "the tax is sent by the wallet too")
+}
+
+func TestGetSwapAmounts(t *testing.T) {
+	pairABI, err := pancake.PancakePairMetaData.GetAbi()
+	assert.Nil(t, err)
+	swapEvent := pairABI.Events["Swap"]
+	swapLog := func(amount0In, amount1In, amount0Out, amount1Out int64) *types.Log {
+		data, err := swapEvent.Inputs.NonIndexed().Pack(big.NewInt(amount0In), big.NewInt(amount1In), big.NewInt(amount0Out), big.NewInt(amount1Out))
+		assert.Nil(t, err)
+		return &types.Log{Topics: []common.Hash{swapEvent.ID, {}, {}}, Data: data}
+	}
+	approval := &types.Log{Topics: []common.Hash{common.HexToHash("0x8c5be1e5")}}
+
+	testCases := []struct {
+		name      string
+		logs      []*types.Log
+		amountIn  string
+		amountOut string
+	}{
+		{"buy", []*types.Log{approval, swapLog(1000, 0, 0, 1993)}, "1000", "1993"},
+		{"sell", []*types.Log{swapLog(0, 1993, 998, 0)}, "1993", "998"},
+		{"multi hop", []*types.Log{swapLog(1000, 0, 0, 50), swapLog(0, 50, 7000, 0)}, "1000", "7000"},
+		{"no swap", []*types.Log{approval}, "", ""},
+	}
+	for _, tc := range testCases {
+		amountIn, amountOut, err := GetSwapAmounts(&types.Receipt{Logs: tc.logs})
+		if tc.amountIn == "" {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		if assert.NoError(t, err, tc.name) {
+			assert.Equal(t, tc.amountIn, amountIn.String(), tc.name)
+			assert.Equal(t, tc.amountOut, amountOut.String(), tc.name)
+		}
+	}
+}
+
+func TestDexFees(t *testing.T) {
+	dex := &Dex{FeeBps: 25}
+	testCases := []struct {
+		amountIn  int64
+		amountOut int64
+		feeIn     string
+		feeOut    string
+	}{
+		{10000, 9975, "25", "25"},
+		{1000000, 997500, "2500", "2500"},
+		{0, 0, "0", "0"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.feeIn, dex.FeeFromAmountIn(big.NewInt(tc.amountIn)).String())
+		assert.Equal(t, tc.feeOut, dex.FeeFromAmountOut(big.NewInt(tc.amountOut)).String(), "the fee on the output should be that of the input it came from")
+	}
+}
+
+// exactOutputRouter quotes every output at quote and records the exact-output
+// sell it is asked for.
+type exactOutputRouter struct {
+	DexRouter
+	quote       *big.Int
+	quotedOut   *big.Int
+	amountOut   *big.Int
+	amountInMax *big.Int
+}
+
+func (r *exactOutputRouter) GetAmountsIn(opts *bind.CallOpts, amountOut *big.Int, path []common.Address) ([]*big.Int, error) {
+	r.quotedOut = amountOut
+	return []*big.Int{r.quote, amountOut}, nil
+}
+
+func (r *exactOutputRouter) SwapTokensForExactETH(opts *bind.TransactOpts, amountOut *big.Int, amountInMax *big.Int, path []common.Address, to common.Address, deadline *big.Int) (*types.Transaction, error) {
+	r.amountOut, r.amountInMax = amountOut, amountInMax
+	return types.NewTx(&types.LegacyTx{}), nil
+}
+
+func TestTokensForExactEth(t *testing.T) {
+	wallet, err := eth.NewWallet("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318", 56)
+	assert.NoError(t, err)
+	token := &eth.Token{Contract: &eth.Contract{Address: common.HexToAddress("0x1001")}}
+	weth := &eth.Token{Contract: &eth.Contract{Address: common.HexToAddress("0x1002")}}
+	router := &exactOutputRouter{quote: big.NewInt(1000)}
+	sw := &DexSwap{
+		FromWallet:  wallet,
+		SwapFunc:    TokensForExactEth,
+		TokenIn:     token,
+		TokenOut:    weth,
+		AmountIn:    big.NewInt(5000),
+		AmountOut:   big.NewInt(200),
+		SlippageBps: 100,
+		Expiration:  big.NewInt(60),
+	}
+
+	quote, err := sw.QuoteAmountIn(router, context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "200", router.quotedOut.String(), "the exact output should be quoted")
+	assert.NoError(t, sw.LimitAmountIn(quote))
+
+	_, err = sw.SwapFunc(router, sw, &bind.TransactOpts{})
+	assert.NoError(t, err)
+	assert.Equal(t, "200", router.amountOut.String())
+	assert.Equal(t, "1010", router.amountInMax.String(), "max input should be the quote with slippage")
+
+	sw.AmountIn = big.NewInt(900)
+	assert.Error(t, sw.LimitAmountIn(quote), "a position below the quote cannot get the output")
+}
@@ -2,58 +2,143 @@ package triggers
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"math/big"
 	"time"
 
 	eth "sniper/pkg/eth"
 	"sniper/pkg/swap"
 
-	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+	"github.com/ethereum/go-ethereum/params"
 )
 
+// TxFilter matches liquidity transactions. Liquidity limits are checked on the
+// amounts the transaction adds, MaxDeployerShare is the largest fraction of the
+// token supply the sender may keep after adding liquidity.
 type TxFilter struct {
 	From              []common.Address
 	To                []common.Address
 	Methods           []string
 	TargetTokenFields []string
+	MinLiquidity      *big.Int
+	MinTokenLiquidity *big.Int
+	MaxDeployerShare  *big.Float
 }
 
+type TriggerMode string
+
+const (
+	MempoolMode        TriggerMode = "mempool"
+	PairCreatedMode    TriggerMode = "pairCreated"
+	TradingEnabledMode TriggerMode = "tradingEnabled"
+)
+
+// BuyTrigger either watches the mempool for liquidity transactions matching
+// MempoolFilter, or waits for the pair with BaseToken to be created and for
+// MinLiquidity of BaseToken to be minted into it. Pair events are subscribed
+// to through Network. For tokens whose liquidity already exists, it watches
+// the mempool for calls matching TradingFilter while simulating ProbeSwap,
+// firing on whichever shows trading is enabled first. BuyIfEnabled fires
+// right away when trading turns out to be enabled already.
 type BuyTrigger struct {
+	Network       *eth.Network
 	Deadline      *time.Time
+	Mode          TriggerMode
 	MempoolFilter TxFilter
+	BaseToken     common.Address
+	MinLiquidity  *big.Int
+	MaxBuyTax     *big.Float
+	MaxSellTax    *big.Float
+	Simulator     *swap.TradeSimulator
+	TradingFilter TradingFilter
+	ProbeSwap     *swap.DexSwap
+	ProbeAmount   *big.Int
+	ProbeInterval time.Duration
+	BuyIfEnabled  bool
 }
 
-func (bt *BuyTrigger) Set(client *ethclient.Client, geth *gethclient.Client, DEX *swap.Dex, targetToken *eth.Token) <-chan struct{} {
-	trigger := make(chan struct{})
-	fire := func() { trigger <- struct{}{} }
+// Set fires with the matched liquidity or trading transaction, or nil when
+// the deadline is reached first or a simulated buy found trading enabled.
+// When a Simulator is set, the trade is simulated before firing and the
+// channel is closed without firing if the token fails the tax limits. It is
+// also closed without firing when the pair can no longer be watched.
+func (bt *BuyTrigger) Set(client *ethclient.Client, mempool *MempoolHub, DEX *swap.Dex, targetToken *eth.Token) <-chan *types.Transaction {
+	trigger := make(chan *types.Transaction)
+	fire := func(tx *types.Transaction) { trigger <- tx }
 
-	deadline, _ := context.WithCancel(context.Background())
+	deadline, cancel := withDeadline(bt.Deadline)
 	if bt.Deadline != nil {
-		deadline, _ = context.WithDeadline(deadline, *bt.Deadline)
 		log.Printf("Set deadline to buy at %s", bt.Deadline.String())
 	}
 
 	go func() {
 		defer close(trigger)
+		defer cancel()
 
-		pendingTxs := ListenForPendingTxs(geth, client, deadline)
+		var pendingTxs <-chan *PendingTx
+		var liquidityAdded <-chan error
+		var tradingEnabled <-chan struct{}
+		switch bt.Mode {
+		case PairCreatedMode:
+			liquidityAdded = bt.watchPairLiquidity(deadline, client, DEX, targetToken)
+		case TradingEnabledMode:
+			owners := bt.tradingOwners(deadline, client, targetToken)
+			pendingTxs = mempool.Subscribe(deadline, targetToken.Symbol, func(tx *PendingTx) bool {
+				return bt.isTradingTransaction(targetToken, owners, tx)
+			})
+			tradingEnabled = bt.watchTradingEnabled(deadline, client, DEX, targetToken)
+		default:
+			pendingTxs = mempool.Subscribe(deadline, targetToken.Symbol, func(tx *PendingTx) bool {
+				return bt.isTargetTransaction(targetToken, tx)
+			})
+		}
 
 		for {
 			select {
 			case <-deadline.Done():
 				log.Printf("Buy deadline reached")
-				fire()
+				if bt.vetoes(targetToken, nil) {
+					return
+				}
+				fire(nil)
 				return
-			case tx := <-pendingTxs:
-				if bt.isTargetTransaction(client, DEX.RouterContract.ABI, targetToken, tx) {
-					log.Printf("Found target transaction")
-					fire()
+			case pending := <-pendingTxs:
+				if bt.Mode != TradingEnabledMode {
+					err := bt.checkLiquidity(DEX, targetToken, pending.From, pending.Tx)
+					if err != nil {
+						log.Printf("Rejected liquidity transaction %s: %s", pending.Tx.Hash().Hex(), err)
+						continue
+					}
+				}
+				log.Printf("Found target transaction")
+				if bt.vetoes(targetToken, pending.Tx) {
 					return
 				}
+				fire(pending.Tx)
+				return
+			case err := <-liquidityAdded:
+				if err != nil {
+					log.Printf("Aborting buy: %s", err)
+					return
+				}
+				log.Printf("Found target pair liquidity")
+				if bt.vetoes(targetToken, nil) {
+					return
+				}
+				fire(nil)
+				return
+			case <-tradingEnabled:
+				log.Printf("Simulated buy passes, trading is enabled")
+				if bt.vetoes(targetToken, nil) {
+					return
+				}
+				fire(nil)
+				return
 			}
 		}
 	}()
@@ -61,8 +146,38 @@ func (bt *BuyTrigger) Set(client *ethclient.Client, geth *gethclient.Client, DEX
 	return trigger
 }
 
-func (bt *BuyTrigger) isTargetTransaction(client *ethclient.Client, contractAbi abi.ABI, targetToken *eth.Token, tx *types.Transaction) bool {
-	to := tx.To()
+// vetoes simulates the trade on top of the matched transaction, if any.
+func (bt *BuyTrigger) vetoes(targetToken *eth.Token, tx *types.Transaction) bool {
+	if bt.Simulator == nil {
+		return false
+	}
+
+	sim, err := bt.Simulator.Simulate(context.Background(), tx)
+	if err != nil {
+		log.Printf("Vetoing buy, trade simulation failed: %s", err)
+		return true
+	}
+	sim.Log(targetToken.Symbol)
+
+	if sim.SellReverts {
+		log.Printf("Vetoing buy, %s cannot be sold", targetToken.Symbol)
+		return true
+	}
+	if bt.MaxBuyTax != nil && sim.BuyTax.Cmp(bt.MaxBuyTax) > 0 {
+		log.Printf("Vetoing buy, buy tax above %.2f", bt.MaxBuyTax)
+		return true
+	}
+	if bt.MaxSellTax != nil && sim.SellTax.Cmp(bt.MaxSellTax) > 0 {
+		log.Printf("Vetoing buy, sell tax above %.2f", bt.MaxSellTax)
+		return true
+	}
+	return false
+}
+
+// isTargetTransaction matches the decoded transaction against MempoolFilter,
+// leaving the liquidity checks which need calls to the node to checkLiquidity.
+func (bt *BuyTrigger) isTargetTransaction(targetToken *eth.Token, pending *PendingTx) bool {
+	to := pending.Tx.To()
 	if to == nil {
 		return false
 	}
@@ -72,28 +187,23 @@ func (bt *BuyTrigger) isTargetTransaction(client *ethclient.Client, contractAbi
 		}
 	}
 
-	from, err := eth.GetTxSender(client, tx)
-	if err != nil {
-		return false
-	}
 	if len(bt.MempoolFilter.From) > 0 {
-		if !arrContains(bt.MempoolFilter.From, *from) {
+		if !arrContains(bt.MempoolFilter.From, pending.From) {
 			return false
 		}
 	}
 
-	method, args, err := eth.GetTxCallData(contractAbi, tx)
-	if err != nil {
+	if pending.Method == nil {
 		return false
 	}
 	if len(bt.MempoolFilter.Methods) > 0 {
-		if !arrContains(bt.MempoolFilter.Methods, method.Name) {
+		if !arrContains(bt.MempoolFilter.Methods, pending.Method.Name) {
 			return false
 		}
 	}
 
 	if len(bt.MempoolFilter.TargetTokenFields) > 0 {
-		if !argsContainsValueOnOneOfTheseField(args, targetToken.Address, bt.MempoolFilter.TargetTokenFields) {
+		if !argsContainsValueOnOneOfTheseField(pending.Args, targetToken.Address, bt.MempoolFilter.TargetTokenFields) {
 			return false
 		}
 	}
@@ -101,6 +211,53 @@ func (bt *BuyTrigger) isTargetTransaction(client *ethclient.Client, contractAbi
 	return true
 }
 
+func (bt *BuyTrigger) checkLiquidity(DEX *swap.Dex, targetToken *eth.Token, from common.Address, tx *types.Transaction) error {
+	filter := bt.MempoolFilter
+	if filter.MinLiquidity == nil && filter.MinTokenLiquidity == nil && filter.MaxDeployerShare == nil {
+		return nil
+	}
+
+	baseAmount, tokenAmount, err := DEX.GetPendingLiquidity(tx, bt.BaseToken, targetToken.Address)
+	if err != nil {
+		return err
+	}
+	if filter.MinLiquidity != nil && baseAmount.Cmp(filter.MinLiquidity) < 0 {
+		return fmt.Errorf("adds %f of base liquidity, below minimum of %f", eth.FromWei(baseAmount, params.Ether), eth.FromWei(filter.MinLiquidity, params.Ether))
+	}
+	if filter.MinTokenLiquidity != nil && tokenAmount.Cmp(filter.MinTokenLiquidity) < 0 {
+		return fmt.Errorf("adds %f %s of liquidity, below minimum of %f", eth.FromWei(tokenAmount, params.Ether), targetToken.Symbol, eth.FromWei(filter.MinTokenLiquidity, params.Ether))
+	}
+
+	if filter.MaxDeployerShare != nil {
+		// the liquidity tokens are still held by the deployer at the latest
+		// block, the pending one may already have moved them to the pair
+		opts := &bind.CallOpts{}
+		supply, err := targetToken.TotalSupply(opts)
+		if err != nil {
+			return fmt.Errorf("cannot get %s total supply: %s", targetToken.Symbol, err)
+		}
+		balance, err := targetToken.BalanceOf(opts, from)
+		if err != nil {
+			return fmt.Errorf("cannot get %s balance of deployer: %s", targetToken.Symbol, err)
+		}
+
+		heldBack := new(big.Int).Sub(balance, tokenAmount)
+		if heldBack.Sign() < 0 {
+			heldBack.SetInt64(0)
+		}
+		share, err := eth.TokenRatio(heldBack, supply)
+		if err != nil {
+			return err
+		}
+		if share.Cmp(filter.MaxDeployerShare) > 0 {
+			return fmt.Errorf("deployer keeps %.2f%% of %s supply, above maximum of %.2f%%",
+				new(big.Float).Mul(share, big.NewFloat(100)), targetToken.Symbol, new(big.Float).Mul(filter.MaxDeployerShare, big.NewFloat(100)))
+		}
+	}
+
+	return nil
+}
+
 func argsContainsValueOnOneOfTheseField[T comparable](args map[string]interface{}, targetValue T, possibleFields []string) bool {
 	for _, field := range possibleFields {
 		value, ok := args[field].(T)
@@ -123,33 +280,9 @@ func arrContains[T comparable](arr []T, e T) bool {
 	return false
 }
 
-func ListenForPendingTxs(gethClient *gethclient.Client, client *ethclient.Client, ctx context.Context) <-chan *types.Transaction {
-	txHashes := make(chan common.Hash)
-	txs := make(chan *types.Transaction)
-	log.Printf("Listening for pending transactions from node mempool...\n")
-
-	go func() {
-		sub, err := gethClient.SubscribePendingTransactions(ctx, txHashes)
-		if err != nil {
-			log.Fatalf("Failed to subscribe to transactions mempool: %s\n", err)
-		}
-		defer sub.Unsubscribe()
-		defer close(txs)
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case err := <-sub.Err():
-				log.Fatalf("Received error from mempool subscription: %s\n", err)
-			case hash := <-txHashes:
-				tx, _, err := client.TransactionByHash(ctx, hash)
-				if err == nil {
-					txs <- tx
-				}
-			}
-		}
-	}()
-
-	return txs
+func withDeadline(deadline *time.Time) (context.Context, context.CancelFunc) {
+	if deadline == nil {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), *deadline)
 }
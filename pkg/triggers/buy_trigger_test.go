@@ -0,0 +1,84 @@
+package triggers
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"sniper/contracts/tokens"
+	eth "sniper/pkg/eth"
+	"sniper/pkg/swap"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+// supplyNode answers the token calls of checkLiquidity. Its pending state
+// already moved the liquidity tokens of the deployer to the pair.
+type supplyNode struct {
+	supply, balance, added *big.Int
+}
+
+func (n *supplyNode) Call(args map[string]interface{}, block string) (hexutil.Bytes, error) {
+	input := hexutil.MustDecode(args["data"].(string))
+	switch common.Bytes2Hex(input[:4]) {
+	case common.Bytes2Hex(crypto.Keccak256([]byte("totalSupply()"))[:4]):
+		return common.LeftPadBytes(n.supply.Bytes(), 32), nil
+	case common.Bytes2Hex(crypto.Keccak256([]byte("balanceOf(address)"))[:4]):
+		balance := n.balance
+		if block == "pending" {
+			balance = new(big.Int).Sub(balance, n.added)
+		}
+		return common.LeftPadBytes(balance.Bytes(), 32), nil
+	}
+	return nil, errors.New("unexpected call")
+}
+
+func TestCheckLiquidity(t *testing.T) {
+	ether := func(amount int64) *big.Int { return new(big.Int).Mul(big.NewInt(amount), big.NewInt(1e18)) }
+	node := &supplyNode{supply: ether(1000), balance: ether(600), added: ether(500)}
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("eth", node))
+	defer server.Stop()
+	client := ethclient.NewClient(rpc.DialInProc(server))
+
+	weth, tokenAddr := common.HexToAddress("0x1001"), common.HexToAddress("0x1002")
+	dex, err := swap.SetupDex(client, common.HexToAddress("0x1003"), common.HexToAddress("0x1004"))
+	assert.Nil(t, err)
+	erc20, err := tokens.NewErc20Token(tokenAddr, client)
+	assert.Nil(t, err)
+	token := &eth.Token{Contract: &eth.Contract{Address: tokenAddr}, Erc20Token: erc20, Symbol: "TKN"}
+
+	data, err := dex.RouterContract.ABI.Pack("addLiquidityETH", tokenAddr, ether(500), big.NewInt(0), big.NewInt(0), common.Address{}, big.NewInt(0))
+	assert.Nil(t, err)
+	tx := types.NewTransaction(0, dex.RouterContract.Address, ether(10), 500000, big.NewInt(1), data)
+
+	// the deployer keeps 100 of the 1000 tokens after adding 500 and 10 coins
+	testCases := []struct {
+		name   string
+		filter TxFilter
+		passes bool
+	}{
+		{"no limits", TxFilter{}, true},
+		{"base liquidity at minimum", TxFilter{MinLiquidity: ether(10)}, true},
+		{"base liquidity below minimum", TxFilter{MinLiquidity: ether(11)}, false},
+		{"token liquidity at minimum", TxFilter{MinTokenLiquidity: ether(500)}, true},
+		{"token liquidity below minimum", TxFilter{MinTokenLiquidity: ether(501)}, false},
+		{"deployer share under maximum", TxFilter{MaxDeployerShare: big.NewFloat(0.15)}, true},
+		{"deployer share above maximum", TxFilter{MaxDeployerShare: big.NewFloat(0.05)}, false},
+	}
+	for _, tc := range testCases {
+		bt := &BuyTrigger{BaseToken: weth, MempoolFilter: tc.filter}
+		err := bt.checkLiquidity(dex, token, common.HexToAddress("0x2001"), tx)
+		if tc.passes {
+			assert.Nil(t, err, tc.name)
+		} else {
+			assert.Error(t, err, tc.name)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package triggers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	eth "sniper/pkg/eth"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// PendingTx is a pending transaction with its sender and call data decoded
+// once for every subscriber. Method and Args are nil when the call data does
+// not match the hub ABI.
+type PendingTx struct {
+	Tx     *types.Transaction
+	From   common.Address
+	Method *abi.Method
+	Args   map[string]interface{}
+}
+
+// TxMatcher selects the transactions delivered to a subscriber. It runs on
+// the hub workers, so it must not block.
+type TxMatcher func(tx *PendingTx) bool
+
+const (
+	DefaultMempoolWorkers    = 8
+	DefaultMempoolBufferSize = 256
+)
+
+// MempoolHub owns a single pending transactions subscription. Its workers
+// resolve the announced hashes and fan the decoded transactions out to every
+// subscriber, dropping them for subscribers whose buffer is full.
+type MempoolHub struct {
+	Workers    int
+	BufferSize int
+
+	network *eth.Network
+	client  *ethclient.Client
+	abi     abi.ABI
+
+	mu          sync.Mutex
+	started     bool
+	fullBodies  bool
+	subscribers []*hubSubscriber
+	// dropped keeps the drop counts of the subscribers gone
+	dropped map[string]uint64
+
+	seen      uint64
+	resolved  uint64
+	overflown uint64
+}
+
+type hubSubscriber struct {
+	name    string
+	ctx     context.Context
+	match   TxMatcher
+	txs     chan *PendingTx
+	dropped uint64
+}
+
+type MempoolStats struct {
+	Seen     uint64
+	Resolved uint64
+	// Overflown counts transactions dropped while every worker was busy
+	Overflown uint64
+	Dropped   map[string]uint64
+}
+
+// NewMempoolHub creates a hub decoding call data with contractABI.
+func NewMempoolHub(network *eth.Network, client *ethclient.Client, contractABI abi.ABI) *MempoolHub {
+	return &MempoolHub{
+		Workers:    DefaultMempoolWorkers,
+		BufferSize: DefaultMempoolBufferSize,
+		network:    network,
+		client:     client,
+		abi:        contractABI,
+	}
+}
+
+// Subscribe registers a subscriber receiving the pending transactions accepted
+// by match until ctx is done. The subscription is opened on the first call.
+// The channel is not closed, receivers should stop on ctx instead.
+func (h *MempoolHub) Subscribe(ctx context.Context, name string, match TxMatcher) <-chan *PendingTx {
+	sub := &hubSubscriber{
+		name:  name,
+		ctx:   ctx,
+		match: match,
+		txs:   make(chan *PendingTx, h.BufferSize),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers = append(h.subscribers, sub)
+	if !h.started {
+		h.started = true
+		go h.run()
+	}
+
+	go func() {
+		<-ctx.Done()
+		h.unsubscribe(sub)
+	}()
+
+	return sub.txs
+}
+
+// FullBodies tells whether the node sends full pending transactions, sparing
+// a TransactionByHash call for each of them.
+func (h *MempoolHub) FullBodies() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.fullBodies
+}
+
+func (h *MempoolHub) setFullBodies(full bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fullBodies = full
+}
+
+func (h *MempoolHub) Stats() MempoolStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := MempoolStats{
+		Seen:      atomic.LoadUint64(&h.seen),
+		Resolved:  atomic.LoadUint64(&h.resolved),
+		Overflown: atomic.LoadUint64(&h.overflown),
+		Dropped:   make(map[string]uint64, len(h.subscribers)),
+	}
+	for name, dropped := range h.dropped {
+		stats.Dropped[name] += dropped
+	}
+	for _, sub := range h.subscribers {
+		stats.Dropped[sub.name] += atomic.LoadUint64(&sub.dropped)
+	}
+	return stats
+}
+
+func (h *MempoolHub) unsubscribe(sub *hubSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, s := range h.subscribers {
+		if s == sub {
+			h.subscribers = append(h.subscribers[:i], h.subscribers[i+1:]...)
+			break
+		}
+	}
+	if dropped := atomic.LoadUint64(&sub.dropped); dropped > 0 {
+		log.Printf("Mempool subscriber %s dropped %d transactions", sub.name, dropped)
+		if h.dropped == nil {
+			h.dropped = make(map[string]uint64)
+		}
+		h.dropped[sub.name] += dropped
+	}
+}
+
+// run feeds the subscribers until the subscription fails for good, after
+// which the next subscriber starts it again.
+func (h *MempoolHub) run() {
+	defer func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.started = false
+	}()
+
+	ctx := context.Background()
+	chainID, err := h.client.ChainID(ctx)
+	if err != nil {
+		log.Fatalf("Failed to get network Chain ID: %s\n", err)
+	}
+	signer := types.LatestSignerForChainID(chainID)
+
+	notifications := make(chan json.RawMessage)
+	sub := h.network.Subscribe("mempool", func(ctx context.Context) (event.Subscription, error) {
+		return h.subscribe(ctx, notifications)
+	})
+	defer sub.Unsubscribe()
+
+	pending := make(chan pendingJob, h.BufferSize)
+	// stops the workers
+	defer close(pending)
+	for i := 0; i < h.Workers; i++ {
+		go h.resolve(ctx, signer, pending)
+	}
+
+	reportedHashes := false
+	for {
+		select {
+		case <-sub.Err():
+			return
+		case msg := <-notifications:
+			atomic.AddUint64(&h.seen, 1)
+			job, err := parseNotification(msg)
+			if err != nil {
+				log.Printf("Cannot decode pending transaction notification: %s", err)
+				continue
+			}
+			// nodes ignoring the full bodies flag keep sending hashes
+			if job.tx == nil && h.FullBodies() && !reportedHashes {
+				log.Printf("Node sends pending transaction hashes only, resolving them by hash")
+				reportedHashes = true
+			}
+			select {
+			case pending <- job:
+			default:
+				atomic.AddUint64(&h.overflown, 1)
+			}
+		}
+	}
+}
+
+// subscribe asks for full pending transactions, falling back to hashes on
+// nodes which do not support them.
+func (h *MempoolHub) subscribe(ctx context.Context, notifications chan json.RawMessage) (*rpc.ClientSubscription, error) {
+	rpcClient := h.network.Rpc()
+
+	sub, err := rpcClient.EthSubscribe(ctx, notifications, "newPendingTransactions", true)
+	if err == nil {
+		h.setFullBodies(true)
+		log.Printf("Listening for full pending transactions from node mempool...\n")
+		return sub, nil
+	}
+	log.Printf("Node does not support full pending transactions: %s", err)
+
+	sub, err = rpcClient.EthSubscribe(ctx, notifications, "newPendingTransactions")
+	if err != nil {
+		return nil, err
+	}
+	h.setFullBodies(false)
+	log.Printf("Listening for pending transaction hashes from node mempool...\n")
+	return sub, nil
+}
+
+type pendingJob struct {
+	hash common.Hash
+	tx   *types.Transaction
+}
+
+// parseNotification reads either a transaction hash or a full transaction.
+func parseNotification(msg json.RawMessage) (pendingJob, error) {
+	var job pendingJob
+	if len(msg) > 0 && msg[0] == '"' {
+		err := json.Unmarshal(msg, &job.hash)
+		return job, err
+	}
+
+	job.tx = new(types.Transaction)
+	err := json.Unmarshal(msg, job.tx)
+	if err != nil {
+		return job, err
+	}
+	job.hash = job.tx.Hash()
+	return job, nil
+}
+
+func (h *MempoolHub) resolve(ctx context.Context, signer types.Signer, jobs <-chan pendingJob) {
+	for job := range jobs {
+		tx := job.tx
+		if tx == nil {
+			var err error
+			tx, _, err = h.client.TransactionByHash(ctx, job.hash)
+			if err != nil {
+				continue
+			}
+		}
+		from, err := types.Sender(signer, tx)
+		if err != nil {
+			continue
+		}
+		atomic.AddUint64(&h.resolved, 1)
+
+		pending := &PendingTx{Tx: tx, From: from}
+		pending.Method, pending.Args, err = eth.GetTxCallData(h.abi, tx)
+		if err != nil {
+			pending.Method, pending.Args = nil, nil
+		}
+		h.dispatch(pending)
+	}
+}
+
+func (h *MempoolHub) dispatch(tx *PendingTx) {
+	h.mu.Lock()
+	subs := append([]*hubSubscriber{}, h.subscribers...)
+	h.mu.Unlock()
+
+	for _, sub := range subs {
+		if sub.ctx.Err() != nil {
+			continue
+		}
+		if sub.match != nil && !sub.match(tx) {
+			continue
+		}
+		select {
+		case sub.txs <- tx:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
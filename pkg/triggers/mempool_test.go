@@ -0,0 +1,57 @@
+package triggers
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMempoolHubDispatch(t *testing.T) {
+	hub := &MempoolHub{BufferSize: 2}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wanted := common.HexToAddress("0x1")
+	all := &hubSubscriber{name: "all", ctx: ctx, txs: make(chan *PendingTx, 2)}
+	some := &hubSubscriber{name: "some", ctx: ctx, txs: make(chan *PendingTx, 2), match: func(tx *PendingTx) bool {
+		return tx.From == wanted
+	}}
+	hub.subscribers = []*hubSubscriber{all, some}
+
+	for i := 0; i < 3; i++ {
+		tx := types.NewTransaction(uint64(i), common.Address{}, big.NewInt(0), 21000, big.NewInt(1), nil)
+		hub.dispatch(&PendingTx{Tx: tx, From: wanted})
+	}
+	hub.dispatch(&PendingTx{Tx: types.NewTransaction(3, common.Address{}, big.NewInt(0), 21000, big.NewInt(1), nil)})
+
+	assert.Len(t, all.txs, 2)
+	assert.Len(t, some.txs, 2)
+	stats := hub.Stats()
+	assert.Equal(t, uint64(2), stats.Dropped["all"], "slow subscriber should drop what does not fit its buffer")
+	assert.Equal(t, uint64(1), stats.Dropped["some"], "unmatched transactions should not count as dropped")
+
+	hub.unsubscribe(all)
+	assert.Equal(t, uint64(2), hub.Stats().Dropped["all"], "drops should be kept after unsubscribing")
+}
+
+func TestParseNotification(t *testing.T) {
+	tx := types.NewTransaction(5, common.HexToAddress("0x1"), big.NewInt(10), 21000, big.NewInt(1), nil)
+
+	job, err := parseNotification([]byte(`"` + tx.Hash().Hex() + `"`))
+	assert.Nil(t, err)
+	assert.Nil(t, job.tx, "hash notifications need to be resolved")
+	assert.Equal(t, tx.Hash(), job.hash)
+
+	body, err := tx.MarshalJSON()
+	assert.Nil(t, err)
+	job, err = parseNotification(body)
+	assert.Nil(t, err)
+	if assert.NotNil(t, job.tx) {
+		assert.Equal(t, tx.Hash(), job.tx.Hash())
+	}
+	assert.Equal(t, tx.Hash(), job.hash)
+}
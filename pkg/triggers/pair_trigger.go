@@ -0,0 +1,176 @@
+package triggers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+
+	pancake "sniper/contracts/bsc/pancakeswap"
+	eth "sniper/pkg/eth"
+	"sniper/pkg/swap"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// watchPairLiquidity waits for the pair of the target and base tokens to be
+// created and then for its Mint events to add at least MinLiquidity of the
+// base token. The returned channel receives nil once they do, or the error
+// that stopped the wait before the deadline.
+func (bt *BuyTrigger) watchPairLiquidity(ctx context.Context, client *ethclient.Client, DEX *swap.Dex, targetToken *eth.Token) <-chan error {
+	ready := make(chan error, 1)
+	log.Printf("Listening for %s pair creation and liquidity...\n", targetToken.Symbol)
+
+	go func() {
+		pairAddr, err := bt.waitForPair(ctx, DEX, targetToken)
+		if err != nil {
+			// the deadline is handled by the trigger itself
+			if ctx.Err() == nil {
+				ready <- fmt.Errorf("Stopped waiting for pair creation: %s", err)
+			}
+			return
+		}
+		log.Printf("Pair %s created for %s", pairAddr.Hex(), targetToken.Symbol)
+
+		err = bt.waitForLiquidity(ctx, client, pairAddr, targetToken)
+		if err != nil {
+			if ctx.Err() == nil {
+				ready <- fmt.Errorf("Stopped waiting for pair liquidity: %s", err)
+			}
+			return
+		}
+		ready <- nil
+	}()
+
+	return ready
+}
+
+// subscribe keeps a subscription alive through bt.Network, signalling on the
+// returned channel every time it is made again, so that events missed in the
+// meantime can be caught up with. Without a Network the subscription is made
+// again on the client alone, with nothing tracking the connection state.
+func (bt *BuyTrigger) subscribe(name string, subscribe func(opts *bind.WatchOpts) (event.Subscription, error)) (event.Subscription, <-chan struct{}) {
+	network := bt.Network
+	if network == nil {
+		network = &eth.Network{MaxBackoff: eth.DefaultMaxBackoff}
+	}
+
+	subscribed := make(chan struct{}, 1)
+	sub := network.Subscribe(name, func(ctx context.Context) (event.Subscription, error) {
+		sub, err := subscribe(&bind.WatchOpts{Context: ctx})
+		if err == nil {
+			select {
+			case subscribed <- struct{}{}:
+			default:
+			}
+		}
+		return sub, err
+	})
+	return sub, subscribed
+}
+
+func (bt *BuyTrigger) waitForPair(ctx context.Context, DEX *swap.Dex, targetToken *eth.Token) (common.Address, error) {
+	created := make(chan *pancake.PancakeFactoryPairCreated)
+	sub, subscribed := bt.subscribe("PairCreated", func(opts *bind.WatchOpts) (event.Subscription, error) {
+		return DEX.Factory.WatchPairCreated(opts, created, nil, nil)
+	})
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return common.Address{}, ctx.Err()
+		case <-subscribed:
+			// the pair may have been created while not subscribed
+			pairAddr, err := DEX.Factory.GetPair(&bind.CallOpts{Context: ctx}, targetToken.Address, bt.BaseToken)
+			if err != nil {
+				log.Printf("Failed to get pair address: %s", err)
+				continue
+			}
+			if pairAddr != (common.Address{}) {
+				return pairAddr, nil
+			}
+		case ev := <-created:
+			if (ev.Token0 == targetToken.Address && ev.Token1 == bt.BaseToken) ||
+				(ev.Token1 == targetToken.Address && ev.Token0 == bt.BaseToken) {
+				return ev.Pair, nil
+			}
+		}
+	}
+}
+
+func (bt *BuyTrigger) waitForLiquidity(ctx context.Context, client *ethclient.Client, pairAddr common.Address, targetToken *eth.Token) error {
+	pair, err := pancake.NewPancakePair(pairAddr, client)
+	if err != nil {
+		return fmt.Errorf("Failed to instantiate pair client: %s", err)
+	}
+
+	mints := make(chan *pancake.PancakePairMint)
+	sub, subscribed := bt.subscribe("Mint", func(opts *bind.WatchOpts) (event.Subscription, error) {
+		return pair.WatchMint(opts, mints, nil)
+	})
+	defer sub.Unsubscribe()
+
+	baseIsToken0 := bytes.Compare(bt.BaseToken.Bytes(), targetToken.Address.Bytes()) < 0
+	baseAmount := func(ev *pancake.PancakePairMint) *big.Int {
+		if baseIsToken0 {
+			return ev.Amount0
+		}
+		return ev.Amount1
+	}
+	liquidity := new(big.Int)
+	// mints from blocks up to readBlock are in the reserves already, those
+	// counted since are kept to be added again on the next reserves read
+	var readBlock uint64
+	var counted []*pancake.PancakePairMint
+
+	for {
+		if liquidity.Sign() > 0 && (bt.MinLiquidity == nil || liquidity.Cmp(bt.MinLiquidity) >= 0) {
+			log.Printf("Pair %s has %f of liquidity", pairAddr.Hex(), eth.FromWei(liquidity, params.Ether))
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-subscribed:
+			// catch up with the mints made while not subscribed
+			block, err := client.BlockNumber(ctx)
+			if err != nil {
+				log.Printf("Failed to get block number: %s", err)
+				continue
+			}
+			reserves, err := pair.GetReserves(&bind.CallOpts{Context: ctx, BlockNumber: new(big.Int).SetUint64(block)})
+			if err != nil {
+				log.Printf("Failed to get pair reserves: %s", err)
+				continue
+			}
+			readBlock = block
+			if baseIsToken0 {
+				liquidity.Set(reserves.Reserve0)
+			} else {
+				liquidity.Set(reserves.Reserve1)
+			}
+			var later []*pancake.PancakePairMint
+			for _, ev := range counted {
+				if ev.Raw.BlockNumber > readBlock {
+					liquidity.Add(liquidity, baseAmount(ev))
+					later = append(later, ev)
+				}
+			}
+			counted = later
+		case ev := <-mints:
+			if ev.Raw.BlockNumber <= readBlock {
+				continue
+			}
+			liquidity.Add(liquidity, baseAmount(ev))
+			counted = append(counted, ev)
+			log.Printf("Liquidity minted on pair %s, now %f", pairAddr.Hex(), eth.FromWei(liquidity, params.Ether))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package triggers
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	pancake "sniper/contracts/bsc/pancakeswap"
+	eth "sniper/pkg/eth"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+// pairNode is at block 10 with the base reserve of the pair, and sends mints
+// to its subscribers, some of them from blocks the reserve already holds.
+type pairNode struct {
+	reserve *big.Int
+	mints   []*types.Log
+}
+
+func (n *pairNode) BlockNumber() hexutil.Uint64 {
+	return 10
+}
+
+func (n *pairNode) Call(args map[string]interface{}, block string) (hexutil.Bytes, error) {
+	if block != "0xa" {
+		return nil, errors.New("reserves should be read at a known block")
+	}
+	pairABI, err := pancake.PancakePairMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	return pairABI.Methods["getReserves"].Outputs.Pack(n.reserve, big.NewInt(0), uint32(0))
+}
+
+func (n *pairNode) Logs(ctx context.Context, crit map[string]interface{}) (*rpc.Subscription, error) {
+	notifier, _ := rpc.NotifierFromContext(ctx)
+	sub := notifier.CreateSubscription()
+	for _, mint := range n.mints {
+		notifier.Notify(sub.ID, mint)
+	}
+	return sub, nil
+}
+
+func TestWaitForLiquidity(t *testing.T) {
+	pairABI, err := pancake.PancakePairMetaData.GetAbi()
+	assert.Nil(t, err)
+	pairAddr := common.HexToAddress("0x2001")
+	mint := func(block uint64, amount int64) *types.Log {
+		data, err := pairABI.Events["Mint"].Inputs.NonIndexed().Pack(big.NewInt(amount), big.NewInt(0))
+		assert.Nil(t, err)
+		return &types.Log{Address: pairAddr, Topics: []common.Hash{pairABI.Events["Mint"].ID, {}}, Data: data, BlockNumber: block}
+	}
+
+	// the reserve of 5 read at block 10 holds the mint of 3 made in it
+	testCases := []struct {
+		name   string
+		mints  []*types.Log
+		filled bool
+	}{
+		{"mint in the reserve read", []*types.Log{mint(10, 3)}, false},
+		{"mint after the reserve read", []*types.Log{mint(10, 3), mint(11, 3)}, false},
+		{"mints reaching the minimum", []*types.Log{mint(10, 3), mint(11, 3), mint(12, 1)}, true},
+	}
+	for _, tc := range testCases {
+		server := rpc.NewServer()
+		assert.Nil(t, server.RegisterName("eth", &pairNode{reserve: big.NewInt(5), mints: tc.mints}))
+		client := ethclient.NewClient(rpc.DialInProc(server))
+
+		bt := &BuyTrigger{BaseToken: common.HexToAddress("0x1001"), MinLiquidity: big.NewInt(9)}
+		token := &eth.Token{Contract: &eth.Contract{Address: common.HexToAddress("0x1002")}}
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		err := bt.waitForLiquidity(ctx, client, pairAddr, token)
+		if tc.filled {
+			assert.Nil(t, err, tc.name)
+		} else {
+			assert.ErrorIs(t, err, context.DeadlineExceeded, "%s: a mint should only count once", tc.name)
+		}
+		cancel()
+		server.Stop()
+	}
+}
@@ -1,45 +1,101 @@
 package triggers
 
 import (
-	"context"
 	"log"
 	"math/big"
-	"sniper/pkg/swap"
 	"time"
+)
+
+type SellReason string
 
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+const (
+	SellOnDeadline     SellReason = "deadline"
+	SellOnTakeProfit   SellReason = "take-profit"
+	SellOnStopLoss     SellReason = "stop-loss"
+	SellOnTrailingStop SellReason = "trailing-stop"
 )
 
+// SellTrigger thresholds are multiples of the buy price. TakeProfit and
+// StopLoss are absolute levels (2.0 sells at twice the buy price, 0.5 at half
+// of it), while TrailingStop is the distance kept below the highest price seen
+// since the buy. Nil thresholds are disabled.
 type SellTrigger struct {
-	Deadline *time.Time
+	Deadline     *time.Time
+	TakeProfit   *big.Float
+	StopLoss     *big.Float
+	TrailingStop *big.Float
 }
 
-func (st *SellTrigger) Set(client *ethclient.Client, geth *gethclient.Client, DEX *swap.Dex, tokenPrices <-chan *big.Float) <-chan struct{} {
-	trigger := make(chan struct{})
-	fire := func() { trigger <- struct{}{} }
+func (st *SellTrigger) Set(buyPrice *big.Float, tokenPrices <-chan *big.Float) <-chan SellReason {
+	trigger := make(chan SellReason)
+	fire := func(reason SellReason) { trigger <- reason }
 
-	deadline, _ := context.WithCancel(context.Background())
+	deadline, cancel := withDeadline(st.Deadline)
 	if st.Deadline != nil {
-		deadline, _ = context.WithDeadline(deadline, *st.Deadline)
 		log.Printf("Set deadline to sell at %s", st.Deadline.String())
 	}
+	st.logThresholds(buyPrice)
 
 	go func() {
 		defer close(trigger)
+		defer cancel()
 
+		peak := new(big.Float).Set(buyPrice)
 		for {
 			select {
 			case <-deadline.Done():
 				log.Printf("Sell deadline reached\n")
-				fire()
-				return
-			default:
-				fire()
+				fire(SellOnDeadline)
 				return
+			case price, ok := <-tokenPrices:
+				if !ok {
+					log.Printf("Price feed closed, will only sell based on time\n")
+					tokenPrices = nil
+					continue
+				}
+				if price.Cmp(peak) > 0 {
+					peak.Set(price)
+				}
+				if reason, hit := st.check(buyPrice, peak, price); hit {
+					log.Printf("Sell %s reached at price %.18f", reason, price)
+					fire(reason)
+					return
+				}
 			}
 		}
 	}()
 
 	return trigger
 }
+
+func (st *SellTrigger) check(buyPrice, peak, price *big.Float) (SellReason, bool) {
+	if st.TakeProfit != nil {
+		if price.Cmp(new(big.Float).Mul(buyPrice, st.TakeProfit)) >= 0 {
+			return SellOnTakeProfit, true
+		}
+	}
+	if st.StopLoss != nil {
+		if price.Cmp(new(big.Float).Mul(buyPrice, st.StopLoss)) <= 0 {
+			return SellOnStopLoss, true
+		}
+	}
+	if st.TrailingStop != nil {
+		distance := new(big.Float).Mul(buyPrice, st.TrailingStop)
+		if price.Cmp(new(big.Float).Sub(peak, distance)) <= 0 {
+			return SellOnTrailingStop, true
+		}
+	}
+	return "", false
+}
+
+func (st *SellTrigger) logThresholds(buyPrice *big.Float) {
+	if st.TakeProfit != nil {
+		log.Printf("Set take-profit at %.18f", new(big.Float).Mul(buyPrice, st.TakeProfit))
+	}
+	if st.StopLoss != nil {
+		log.Printf("Set stop-loss at %.18f", new(big.Float).Mul(buyPrice, st.StopLoss))
+	}
+	if st.TrailingStop != nil {
+		log.Printf("Set trailing stop at %.18f below peak price", new(big.Float).Mul(buyPrice, st.TrailingStop))
+	}
+}
@@ -0,0 +1,57 @@
+package triggers
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var sellTestCases = []struct {
+	peak   float64
+	price  float64
+	reason SellReason
+	hit    bool
+}{
+	{1.0, 1.0, "", false},
+	{1.5, 1.5, "", false},
+	{2.1, 2.1, SellOnTakeProfit, true},
+	{2.0, 2.0, SellOnTakeProfit, true},
+	{1.0, 0.5, SellOnStopLoss, true},
+	{1.0, 0.75, "", false},
+	{1.8, 1.5, SellOnTrailingStop, true},
+	{1.8, 1.6, "", false},
+}
+
+func TestSellTriggerCheck(t *testing.T) {
+	st := &SellTrigger{
+		TakeProfit:   big.NewFloat(2.0),
+		StopLoss:     big.NewFloat(0.5),
+		TrailingStop: big.NewFloat(0.3),
+	}
+	buyPrice := big.NewFloat(1.0)
+
+	for _, tc := range sellTestCases {
+		reason, hit := st.check(buyPrice, big.NewFloat(tc.peak), big.NewFloat(tc.price))
+		assert.Equal(t, tc.hit, hit, "unexpected trigger at price %f", tc.price)
+		assert.Equal(t, tc.reason, reason, "unexpected reason at price %f", tc.price)
+	}
+}
+
+func TestSellTriggerFiresFromPriceFeed(t *testing.T) {
+	st := &SellTrigger{StopLoss: big.NewFloat(0.5)}
+	prices := make(chan *big.Float, 3)
+	prices <- big.NewFloat(0.9)
+	prices <- big.NewFloat(0.7)
+	prices <- big.NewFloat(0.4)
+
+	reason := <-st.Set(big.NewFloat(1.0), prices)
+	assert.Equal(t, SellOnStopLoss, reason)
+}
+
+func TestSellTriggerDisabled(t *testing.T) {
+	st := &SellTrigger{}
+	reason, hit := st.check(big.NewFloat(1.0), big.NewFloat(100.0), big.NewFloat(0.01))
+	assert.False(t, hit)
+	assert.Equal(t, SellReason(""), reason)
+}
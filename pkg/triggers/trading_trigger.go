@@ -0,0 +1,132 @@
+package triggers
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"math/big"
+	"time"
+
+	eth "sniper/pkg/eth"
+	"sniper/pkg/swap"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+const DefaultProbeInterval = 2 * time.Second
+
+var (
+	// DefaultTradingMethods are the switches launch tokens commonly open
+	// trading with
+	DefaultTradingMethods = []string{
+		"enableTrading()", "openTrading()", "startTrading()", "launch()",
+		"setTrading(bool)", "setTradingEnabled(bool)",
+	}
+	DefaultProbeAmount = big.NewInt(1e14)
+)
+
+// TradingFilter matches the calls enabling trading of a token whose liquidity
+// already exists. From defaults to the owner of the token.
+type TradingFilter struct {
+	From      []common.Address
+	Selectors [][]byte
+}
+
+// isTradingTransaction matches calls to the target token itself against
+// TradingFilter.
+func (bt *BuyTrigger) isTradingTransaction(targetToken *eth.Token, owners []common.Address, pending *PendingTx) bool {
+	to := pending.Tx.To()
+	if to == nil || *to != targetToken.Address {
+		return false
+	}
+	if len(owners) > 0 && !arrContains(owners, pending.From) {
+		return false
+	}
+
+	data := pending.Tx.Data()
+	if len(data) < 4 {
+		return false
+	}
+	for _, selector := range bt.TradingFilter.Selectors {
+		if bytes.Equal(data[:4], selector) {
+			return true
+		}
+	}
+	return false
+}
+
+// tradingOwners are the senders allowed to enable trading, none meaning any.
+func (bt *BuyTrigger) tradingOwners(ctx context.Context, client *ethclient.Client, targetToken *eth.Token) []common.Address {
+	if len(bt.TradingFilter.From) > 0 {
+		return bt.TradingFilter.From
+	}
+
+	owner, err := targetToken.GetOwner(client, ctx)
+	if err != nil {
+		log.Printf("Failed to get owner of %s: %s", targetToken.Symbol, err)
+	}
+	if owner == (common.Address{}) {
+		log.Printf("%s has no owner, matching calls enabling trading from any sender", targetToken.Symbol)
+		return nil
+	}
+	log.Printf("Listening for %s owner %s to enable trading...\n", targetToken.Symbol, owner.Hex())
+	return []common.Address{owner}
+}
+
+// watchTradingEnabled simulates a buy of ProbeAmount every ProbeInterval,
+// closing the returned channel once the buy stops reverting. A buy passing
+// before any revert was seen only closes it with BuyIfEnabled, trading may
+// have been open all along and the probe proves nothing then.
+func (bt *BuyTrigger) watchTradingEnabled(ctx context.Context, client *ethclient.Client, DEX *swap.Dex, targetToken *eth.Token) <-chan struct{} {
+	enabled := make(chan struct{})
+	if bt.ProbeSwap == nil {
+		return enabled
+	}
+	amount := bt.ProbeAmount
+	if amount == nil {
+		amount = DefaultProbeAmount
+	}
+	interval := bt.ProbeInterval
+	if interval == 0 {
+		interval = DefaultProbeInterval
+	}
+	log.Printf(
+		"Simulating buys of %f %s every %s until trading of %s is enabled...\n",
+		eth.FromWei(amount, params.Ether), bt.ProbeSwap.TokenIn.Symbol, interval, targetToken.Symbol,
+	)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		reverted := false
+		for {
+			passes, err := bt.ProbeSwap.BuyPasses(client, ctx, DEX.Router, amount)
+			if err != nil {
+				log.Printf("Failed to simulate buy of %s: %s", targetToken.Symbol, err)
+			} else if passes {
+				if !reverted && !bt.BuyIfEnabled {
+					log.Printf("Simulated buy of %s passes on the first try, trading is already enabled, not buying before the deadline", targetToken.Symbol)
+					return
+				}
+				if !reverted {
+					log.Printf("Simulated buy of %s passes on the first try, trading is already enabled", targetToken.Symbol)
+				}
+				close(enabled)
+				return
+			} else {
+				reverted = true
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return enabled
+}
@@ -0,0 +1,33 @@
+package triggers
+
+import (
+	"math/big"
+	"testing"
+
+	eth "sniper/pkg/eth"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTradingTransaction(t *testing.T) {
+	token := &eth.Token{Contract: &eth.Contract{Address: common.HexToAddress("0x1")}}
+	owner := common.HexToAddress("0x2")
+	enable := crypto.Keccak256([]byte("enableTrading()"))[:4]
+	bt := &BuyTrigger{TradingFilter: TradingFilter{Selectors: [][]byte{enable}}}
+
+	call := func(from, to common.Address, data []byte) *PendingTx {
+		tx := types.NewTransaction(0, to, big.NewInt(0), 100000, big.NewInt(1), data)
+		return &PendingTx{Tx: tx, From: from}
+	}
+	owners := []common.Address{owner}
+
+	assert.True(t, bt.isTradingTransaction(token, owners, call(owner, token.Address, enable)))
+	assert.False(t, bt.isTradingTransaction(token, owners, call(common.HexToAddress("0x3"), token.Address, enable)), "only the owner enables trading")
+	assert.True(t, bt.isTradingTransaction(token, nil, call(common.HexToAddress("0x3"), token.Address, enable)), "no owners should match any sender")
+	assert.False(t, bt.isTradingTransaction(token, owners, call(owner, common.HexToAddress("0x4"), enable)), "calls to other contracts should not match")
+	assert.False(t, bt.isTradingTransaction(token, owners, call(owner, token.Address, crypto.Keccak256([]byte("renounceOwnership()"))[:4])))
+	assert.False(t, bt.isTradingTransaction(token, owners, call(owner, token.Address, nil)))
+}